@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/palestamp/barnacle/pkg/service"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/palestamp/barnacle/pkg/api"
 	"github.com/palestamp/barnacle/pkg/apis"
 	"github.com/palestamp/barnacle/pkg/backends"
+	"github.com/palestamp/barnacle/pkg/backends/memory"
 	"github.com/palestamp/barnacle/pkg/backends/postgres"
 	"github.com/palestamp/barnacle/pkg/metadata"
 )
@@ -19,7 +21,10 @@ var (
 	scPostgresURI string
 )
 
-const scPostgresURIDefault = "postgresql://postgres@localhost:5432/barnacle"
+const (
+	scPostgresURIDefault = "postgresql://postgres@localhost:5432/barnacle"
+	scMemoryURI          = "memory://"
+)
 
 func ServeCmd() *cobra.Command {
 	cmd := &cobra.Command{
@@ -29,12 +34,12 @@ func ServeCmd() *cobra.Command {
 	}
 
 	cmd.Flags().StringVar(&scServerAddr, "addr", ":9878", "Address to listen on, ex: localhost:9878")
-	cmd.Flags().StringVar(&scPostgresURI, "metadata-uri", scPostgresURIDefault, "Address of postgres metadata storage")
+	cmd.Flags().StringVar(&scPostgresURI, "metadata-uri", scPostgresURIDefault, "Address of postgres metadata storage, use memory:// to keep metadata in memory")
 	return cmd
 }
 
 func serveCmd(cmd *cobra.Command, args []string) error {
-	metadataStorage, err := metadata.NewPostgresStorage(scPostgresURI)
+	metadataStorage, err := newMetadataStorage(scPostgresURI)
 	if err != nil {
 		return err
 	}
@@ -42,6 +47,7 @@ func serveCmd(cmd *cobra.Command, args []string) error {
 
 	proxy := backends.NewRegistry()
 	proxy.RegisterConnector(api.BackendType("postgres"), postgres.NewConnector())
+	proxy.RegisterConnector(api.BackendType("memory"), memory.NewConnector())
 
 	svc := service.New(proxy, mds)
 
@@ -52,3 +58,10 @@ func serveCmd(cmd *cobra.Command, args []string) error {
 
 	return server.ListenAndServe()
 }
+
+func newMetadataStorage(uri string) (api.MetadataStorage, error) {
+	if strings.HasPrefix(uri, scMemoryURI) {
+		return metadata.NewMemoryStorage(), nil
+	}
+	return metadata.NewPostgresStorage(uri)
+}
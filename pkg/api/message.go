@@ -1,8 +1,19 @@
 package api
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
+	"unicode/utf8"
+)
+
+var (
+	// ErrMessageNotFound - message was acknowledged, cancelled or never existed.
+	ErrMessageNotFound = errors.New("message not found")
+	// ErrMessageInFlight - message is being processed by consumer.
+	ErrMessageInFlight = errors.New("message is in flight")
 )
 
 type MessageID string
@@ -32,16 +43,150 @@ func (d *Delay) UnmarshalJSON(b []byte) error {
 	return err
 }
 
+// DataEncoding defines how message data is represented in JSON.
+type DataEncoding string
+
+const (
+	// PlainEncoding - data is a JSON string as is.
+	PlainEncoding DataEncoding = ""
+	// Base64Encoding - data is standard base64 encoded binary.
+	Base64Encoding DataEncoding = "base64"
+)
+
+func (e DataEncoding) Validate() error {
+	if e != PlainEncoding && e != Base64Encoding {
+		return fmt.Errorf("unknown encoding %q", e)
+	}
+	return nil
+}
+
+// encodeData represents raw data with encoding, data that is not valid
+// UTF-8 can not be represented as JSON string and always becomes base64.
+func encodeData(data string, encoding DataEncoding) (string, DataEncoding) {
+	if encoding == Base64Encoding || !utf8.ValidString(data) {
+		return base64.StdEncoding.EncodeToString([]byte(data)), Base64Encoding
+	}
+	return data, PlainEncoding
+}
+
+func decodeData(data string, encoding DataEncoding) (string, error) {
+	if encoding != Base64Encoding {
+		return data, nil
+	}
+
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", errors.New("data is not valid base64")
+	}
+	return string(b), nil
+}
+
+// MessageAttributes carry message metadata alongside data,
+// values are either strings or numbers.
+type MessageAttributes map[string]interface{}
+
+func (a MessageAttributes) Validate() error {
+	for k, v := range a {
+		if k == "" {
+			return errors.New("attribute name can not be empty")
+		}
+
+		switch v.(type) {
+		case string, float64, float32, int, int32, int64, json.Number:
+		default:
+			return fmt.Errorf("attribute %q must be a string or a number", k)
+		}
+	}
+	return nil
+}
+
 type Message struct {
-	ID          MessageID `json:"id"`
-	CreatedAt   time.Time `json:"created_at"`
-	ScheduledAt time.Time `json:"scheduled_at"`
-	Data        string    `json:"data"`
-	AckKey      string    `json:"ack_key"`
+	ID          MessageID         `json:"id"`
+	CreatedAt   time.Time         `json:"created_at"`
+	ScheduledAt time.Time         `json:"scheduled_at"`
+	ExpiresAt   *time.Time        `json:"expires_at,omitempty"`
+	Data        string            `json:"data"`
+	Encoding    DataEncoding      `json:"encoding,omitempty"`
+	Attributes  MessageAttributes `json:"attributes,omitempty"`
+	AckKey      string            `json:"ack_key,omitempty"`
+	LastError   string            `json:"last_error,omitempty"`
+}
+
+// EncodeData prepares raw message data for JSON transfer.
+func (m *Message) EncodeData(encoding DataEncoding) {
+	m.Data, m.Encoding = encodeData(m.Data, encoding)
+}
+
+// MessageState is a state of message at the moment of peek.
+type MessageState string
+
+const (
+	VisibleMessageState  MessageState = "visible"
+	InFlightMessageState MessageState = "in_flight"
+	DelayedMessageState  MessageState = "delayed"
+	// ExpiredMessageState - message is expired but not removed yet,
+	// it will never be delivered.
+	ExpiredMessageState MessageState = "expired"
+)
+
+func (s MessageState) Validate() error {
+	switch s {
+	case "", VisibleMessageState, InFlightMessageState, DelayedMessageState, ExpiredMessageState:
+		return nil
+	}
+	return fmt.Errorf("unknown message state %q", s)
+}
+
+// PeekFilter narrows set of peeked messages, zero value matches all messages.
+type PeekFilter struct {
+	MessageFilter
+	State MessageState `json:"state"`
+}
+
+// PeekedMessage is a message seen by peek, it has no ack key.
+type PeekedMessage struct {
+	Message
+	State     MessageState `json:"state"`
+	VisibleAt time.Time    `json:"visible_at"`
+	Attempts  int          `json:"attempts"`
+}
+
+// QueueStats is a snapshot of queue backlog.
+type QueueStats struct {
+	// Visible messages can be polled right now, expired ones are not counted.
+	Visible int64 `json:"visible"`
+	// InFlight messages are polled but neither acked nor nacked yet.
+	InFlight int64 `json:"in_flight"`
+	// Delayed messages are not visible yet and are not in flight.
+	Delayed int64 `json:"delayed"`
+	// OldestVisibleAge is a number of seconds since creation of the oldest
+	// visible message.
+	OldestVisibleAge float64 `json:"oldest_visible_age_seconds"`
+	MaxAttempts      int     `json:"max_attempts"`
+	// Expired is a number of messages expired since queue creation.
+	Expired int64 `json:"expired"`
 }
 
 type PollRequest struct {
 	Limit      int
 	Deadline   time.Time
 	Visibility time.Duration
+	Filter     MessageFilter
+}
+
+// MessageFilter narrows set of messages, zero value matches all messages.
+type MessageFilter struct {
+	CreatedAfter  time.Time `json:"created_after"`
+	CreatedBefore time.Time `json:"created_before"`
+}
+
+// Match reports whether message created at createdAt passes filter.
+func (f *MessageFilter) Match(createdAt time.Time) bool {
+	if !f.CreatedAfter.IsZero() && createdAt.Before(f.CreatedAfter) {
+		return false
+	}
+	if !f.CreatedBefore.IsZero() && !createdAt.Before(f.CreatedBefore) {
+		return false
+	}
+	return true
 }
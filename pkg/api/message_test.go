@@ -0,0 +1,56 @@
+package api_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/palestamp/barnacle/pkg/api"
+)
+
+func TestMessageEncodeData(t *testing.T) {
+	m := api.Message{Data: "smaug"}
+	m.EncodeData(api.PlainEncoding)
+	assert.Equal(t, api.Message{Data: "smaug"}, m)
+
+	m = api.Message{Data: "smaug"}
+	m.EncodeData(api.Base64Encoding)
+	assert.Equal(t, api.Message{Data: "c21hdWc=", Encoding: api.Base64Encoding}, m)
+
+	// invalid UTF-8 can not be sent as JSON string.
+	m = api.Message{Data: "\xff\x00"}
+	m.EncodeData(api.PlainEncoding)
+	assert.Equal(t, api.Message{Data: "/wA=", Encoding: api.Base64Encoding}, m)
+}
+
+func TestEnqueueMessageRequestDecodeData(t *testing.T) {
+	emr := api.EnqueueMessageRequest{Data: "/wA=", Encoding: api.Base64Encoding}
+	assert.NoError(t, emr.DecodeData())
+	assert.Equal(t, "\xff\x00", emr.Data)
+	assert.Equal(t, api.PlainEncoding, emr.Encoding)
+
+	emr = api.EnqueueMessageRequest{Data: "!!!", Encoding: api.Base64Encoding}
+	assert.Error(t, emr.DecodeData())
+
+	emr = api.EnqueueMessageRequest{Data: "smaug", Encoding: "rot13"}
+	assert.Error(t, emr.DecodeData())
+}
+
+func TestEnqueueMessageRequestScheduledAt(t *testing.T) {
+	var emr api.EnqueueMessageRequest
+	err := json.Unmarshal([]byte(`{"queue": "erebor", "scheduled_at": "2019-01-07T16:12:18Z"}`), &emr)
+	assert.NoError(t, err)
+	assert.NoError(t, emr.Validate())
+
+	scheduledAt := time.Date(2019, 1, 7, 16, 12, 18, 0, time.UTC)
+	assert.True(t, scheduledAt.Equal(emr.ScheduleTime(time.Now())))
+
+	emr.Delay.Duration = time.Minute
+	assert.Error(t, emr.Validate())
+
+	emr.ScheduledAt = time.Time{}
+	now := time.Now()
+	assert.Equal(t, now.Add(time.Minute), emr.ScheduleTime(now))
+}
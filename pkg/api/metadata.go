@@ -2,7 +2,9 @@ package api
 
 import (
 	"errors"
+	"net/url"
 	"regexp"
+	"time"
 )
 
 var (
@@ -10,6 +12,16 @@ var (
 	ErrQueueIDInvalid = errors.New("queue id invalid")
 	// ErrResourceIDInvalid ...
 	ErrResourceIDInvalid = errors.New("resource id invalid")
+	// ErrQueueNotEmpty - queue can not be deleted without force.
+	ErrQueueNotEmpty = errors.New("queue is not empty")
+	// ErrQueueReferenced - queue used by other queues can not be deleted.
+	ErrQueueReferenced = errors.New("queue is referenced by other queues")
+	// ErrQueuePaused - messages of paused queue can not be polled.
+	ErrQueuePaused = errors.New("queue is paused")
+	// ErrQueueDraining - draining queue does not accept new messages.
+	ErrQueueDraining = errors.New("queue is draining")
+	// ErrQueueStateTransition - queue can not be switched into requested state.
+	ErrQueueStateTransition = errors.New("queue state transition is not allowed")
 
 	queueIDPattern    = regexp.MustCompile(`^[_a-z][_a-z0-9]*$`)
 	resourceIDPattern = queueIDPattern
@@ -18,15 +30,76 @@ var (
 type QueueState string
 
 const (
-	// InactiveQueueState is a state in which queue appears until queue
+	// ProvisioningQueueState is a state in which queue appears until queue
 	// resources provisioned, for example postgres table.
 	// To provision queue we need it's params to be stored in MetadataStorage.
-	InactiveQueueState QueueState = "inactive"
+	ProvisioningQueueState QueueState = "provisioning"
 
 	// ActiveQueueState - queue is active and fully operational
 	ActiveQueueState QueueState = "active"
+
+	// PausedQueueState - queue accepts messages, acknowledgements and other
+	// requests but does not return messages to consumers.
+	PausedQueueState QueueState = "paused"
+
+	// DrainingQueueState - queue does not accept new messages, messages
+	// which are already enqueued are delivered as usual.
+	DrainingQueueState QueueState = "draining"
+
+	// DeletingQueueState - queue is being deleted, it does not accept
+	// requests. Deletion of queue in this state can be resumed.
+	DeletingQueueState QueueState = "deleting"
+
+	// FailedQueueState - queue provisioning failed, queue can only be deleted.
+	FailedQueueState QueueState = "failed"
 )
 
+// QueueStates lists all queue states.
+var QueueStates = []QueueState{
+	ProvisioningQueueState,
+	ActiveQueueState,
+	PausedQueueState,
+	DrainingQueueState,
+	DeletingQueueState,
+	FailedQueueState,
+}
+
+// queueTransitions lists states queue can be switched into from given state.
+var queueTransitions = map[QueueState][]QueueState{
+	ProvisioningQueueState: {ActiveQueueState, FailedQueueState, DeletingQueueState},
+	ActiveQueueState:       {PausedQueueState, DrainingQueueState, DeletingQueueState},
+	PausedQueueState:       {ActiveQueueState, DrainingQueueState, DeletingQueueState},
+	DrainingQueueState:     {ActiveQueueState, PausedQueueState, DeletingQueueState},
+	// deletion can only be completed, queue metadata is removed afterwards
+	DeletingQueueState: {},
+	FailedQueueState:   {DeletingQueueState},
+}
+
+// CanTransitionTo reports whether queue can be switched from s into next
+// state, switching into the current state is always allowed.
+func (s QueueState) CanTransitionTo(next QueueState) bool {
+	if s == next {
+		return true
+	}
+
+	for _, st := range queueTransitions[s] {
+		if st == next {
+			return true
+		}
+	}
+	return false
+}
+
+// QueueTransition is a record of queue state change.
+type QueueTransition struct {
+	// From is empty for the first transition recorded on registration.
+	From QueueState `json:"from,omitempty"`
+	To   QueueState `json:"to"`
+	At   time.Time  `json:"at"`
+	// Error holds the reason of transition into failed state.
+	Error string `json:"error,omitempty"`
+}
+
 // QueueType ...
 type QueueType string
 
@@ -59,22 +132,66 @@ func (q *ResourceID) Validate() error {
 // BackendType ...
 type BackendType string
 
+// DefaultBackendType is used for resources registered without backend.
+const DefaultBackendType BackendType = "postgres"
+
 type QueueOptions map[string]interface{}
 
 type ResourceConnOptions map[string]interface{}
 
 // QueueMetadata ...
 type QueueMetadata struct {
-	QueueID     QueueID
-	ResourceID  ResourceID
-	BackendType BackendType
-	QueueType   QueueType
-	QueueState  QueueState
-	Options     QueueOptions
-	ConnOptions ResourceConnOptions
+	QueueID     QueueID      `json:"id"`
+	ResourceID  ResourceID   `json:"resource"`
+	BackendType BackendType  `json:"backend"`
+	QueueType   QueueType    `json:"type"`
+	QueueState  QueueState   `json:"state"`
+	Options     QueueOptions `json:"options"`
+
+	CreatedAt      time.Time `json:"created_at"`
+	StateChangedAt time.Time `json:"state_changed_at"`
+	// LastError is the reason of the latest transition into failed state.
+	LastError string `json:"last_error,omitempty"`
+	// ConnOptions may hold credentials, they are never exposed via API.
+	ConnOptions ResourceConnOptions `json:"-"`
+
+	// References holds metadata of queues referenced by Options,
+	// for example dead letter queue.
+	References map[QueueID]QueueMetadata `json:"-"`
+}
+
+// Redacted returns copy of options with passwords of URI values masked.
+func (o ResourceConnOptions) Redacted() ResourceConnOptions {
+	out := make(ResourceConnOptions, len(o))
+	for k, v := range o {
+		out[k] = v
+		s, ok := v.(string)
+		if !ok {
+			continue
+		}
+
+		u, err := url.Parse(s)
+		if err != nil || u.User == nil {
+			continue
+		}
+
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+			out[k] = u.String()
+		}
+	}
+	return out
 }
 
 type ResourceMetadata struct {
 	ResourceID  ResourceID          `json:"id"`
+	BackendType BackendType         `json:"backend"`
 	ConnOptions ResourceConnOptions `json:"options"`
 }
+
+func (r *ResourceMetadata) Validate() error {
+	return Check(
+		Ce(r.ResourceID.Validate()),
+		Cb(r.BackendType != "", "backend type can not be empty"),
+	)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+// queueReferenceOptions lists QueueOptions keys that hold QueueID of
+// another queue.
+var queueReferenceOptions = []string{
+	"dead_letter_queue",
+	"expiry_queue",
+}
+
+// QueueReferences returns ids of queues referenced by options.
+func (o QueueOptions) QueueReferences() []QueueID {
+	out := make([]QueueID, 0)
+	for _, key := range queueReferenceOptions {
+		if v, ok := o[key].(string); ok && v != "" {
+			out = append(out, QueueID(v))
+		}
+	}
+	return out
+}
+
+// RetryPolicy defines how redelivery of failed message is delayed.
+type RetryPolicy string
+
+const (
+	// NoRetryPolicy - message is redelivered right after visibility timeout.
+	NoRetryPolicy RetryPolicy = ""
+	// FixedRetryPolicy - redelivery is delayed by RetryDelay.
+	FixedRetryPolicy RetryPolicy = "fixed"
+	// ExponentialRetryPolicy - redelivery delay starts from RetryDelay and
+	// doubles with every failure up to RetryMaxDelay.
+	ExponentialRetryPolicy RetryPolicy = "exponential"
+	// ExponentialJitterRetryPolicy - random delay between zero and
+	// ExponentialRetryPolicy delay.
+	ExponentialJitterRetryPolicy RetryPolicy = "exponential-jitter"
+)
+
+// DefaultDedupWindow is used when queue does not define dedup_window.
+const DefaultDedupWindow = 5 * time.Minute
+
+// DeliveryOptions are queue options which are common for all queue types.
+// Backends embed them into own options with `mapstructure:",squash"` tag.
+type DeliveryOptions struct {
+	// MaxAttempts is a number of deliveries after which message is moved
+	// to DeadLetterQueue instead of being delivered again.
+	MaxAttempts     int     `mapstructure:"max_attempts"`
+	DeadLetterQueue QueueID `mapstructure:"dead_letter_queue"`
+
+	RetryPolicy RetryPolicy `mapstructure:"retry_policy"`
+	// RetryDelay is a backoff after the first failure.
+	RetryDelay time.Duration `mapstructure:"retry_delay"`
+	// RetryMaxDelay caps exponential backoff, zero means no cap.
+	RetryMaxDelay time.Duration `mapstructure:"retry_max_delay"`
+
+	// DedupWindow is a period during which messages with the same
+	// deduplication id are enqueued only once.
+	DedupWindow time.Duration `mapstructure:"dedup_window"`
+
+	// DefaultTTL is applied to messages enqueued without ttl or expires_at,
+	// zero means that messages never expire.
+	DefaultTTL time.Duration `mapstructure:"default_ttl"`
+	// ExpiryQueue receives expired messages, they are dropped if it is not set.
+	ExpiryQueue QueueID `mapstructure:"expiry_queue"`
+}
+
+func (o *DeliveryOptions) Validate() error {
+	knownPolicy := false
+	switch o.RetryPolicy {
+	case NoRetryPolicy, FixedRetryPolicy, ExponentialRetryPolicy, ExponentialJitterRetryPolicy:
+		knownPolicy = true
+	}
+
+	return Check(
+		Cb(o.MaxAttempts >= 0, "max_attempts can not be negative"),
+		Cb((o.MaxAttempts == 0) == (o.DeadLetterQueue == ""), "max_attempts and dead_letter_queue must be set together"),
+		Cb(knownPolicy, "unknown retry_policy %q", o.RetryPolicy),
+		Cb((o.RetryPolicy == NoRetryPolicy) == (o.RetryDelay == 0), "retry_policy and retry_delay must be set together"),
+		Cb(o.RetryDelay >= 0, "retry_delay can not be negative"),
+		Cb(o.RetryMaxDelay == 0 || o.RetryMaxDelay >= o.RetryDelay, "retry_max_delay can not be less than retry_delay"),
+		Cb(o.DedupWindow >= 0, "dedup_window can not be negative"),
+		Cb(o.DefaultTTL >= 0, "default_ttl can not be negative"),
+	)
+}
+
+// DeduplicationWindow returns queue dedup window or DefaultDedupWindow.
+func (o *DeliveryOptions) DeduplicationWindow() time.Duration {
+	if o.DedupWindow == 0 {
+		return DefaultDedupWindow
+	}
+	return o.DedupWindow
+}
+
+// RetryBackoff returns redelivery delay of a message failed n times.
+func (o *DeliveryOptions) RetryBackoff(n int) time.Duration {
+	switch o.RetryPolicy {
+	case FixedRetryPolicy:
+		return o.RetryDelay
+	case ExponentialRetryPolicy, ExponentialJitterRetryPolicy:
+		d := o.RetryDelay
+		for i := 1; i < n && d < math.MaxInt64/2; i++ {
+			if o.RetryMaxDelay != 0 && d >= o.RetryMaxDelay {
+				break
+			}
+			d *= 2
+		}
+
+		if o.RetryMaxDelay != 0 && d > o.RetryMaxDelay {
+			d = o.RetryMaxDelay
+		}
+
+		if o.RetryPolicy == ExponentialJitterRetryPolicy {
+			d = time.Duration(rand.Int63n(int64(d) + 1))
+		}
+		return d
+	}
+	return 0
+}
@@ -0,0 +1,58 @@
+package api_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/palestamp/barnacle/pkg/api"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	fixed := api.DeliveryOptions{RetryPolicy: api.FixedRetryPolicy, RetryDelay: time.Second}
+	assert.Equal(t, time.Second, fixed.RetryBackoff(1))
+	assert.Equal(t, time.Second, fixed.RetryBackoff(10))
+
+	exp := api.DeliveryOptions{
+		RetryPolicy:   api.ExponentialRetryPolicy,
+		RetryDelay:    time.Second,
+		RetryMaxDelay: time.Minute,
+	}
+	assert.Equal(t, time.Second, exp.RetryBackoff(1))
+	assert.Equal(t, 2*time.Second, exp.RetryBackoff(2))
+	assert.Equal(t, 32*time.Second, exp.RetryBackoff(6))
+	assert.Equal(t, time.Minute, exp.RetryBackoff(7))
+	assert.Equal(t, time.Minute, exp.RetryBackoff(1000))
+
+	jitter := exp
+	jitter.RetryPolicy = api.ExponentialJitterRetryPolicy
+	for i := 0; i < 100; i++ {
+		d := jitter.RetryBackoff(3)
+		assert.True(t, d >= 0 && d <= 4*time.Second, "backoff %s out of range", d)
+	}
+
+	none := api.DeliveryOptions{}
+	assert.Equal(t, time.Duration(0), none.RetryBackoff(5))
+}
+
+func TestDeliveryOptionsValidate(t *testing.T) {
+	valid := []api.DeliveryOptions{
+		{},
+		{MaxAttempts: 3, DeadLetterQueue: "erebor_dead"},
+		{RetryPolicy: api.ExponentialRetryPolicy, RetryDelay: time.Second, RetryMaxDelay: time.Minute},
+	}
+	for _, o := range valid {
+		assert.NoError(t, o.Validate())
+	}
+
+	invalid := []api.DeliveryOptions{
+		{MaxAttempts: 3},
+		{RetryPolicy: "linear", RetryDelay: time.Second},
+		{RetryPolicy: api.FixedRetryPolicy},
+		{RetryPolicy: api.ExponentialRetryPolicy, RetryDelay: time.Minute, RetryMaxDelay: time.Second},
+	}
+	for _, o := range invalid {
+		assert.Error(t, o.Validate())
+	}
+}
@@ -1,5 +1,7 @@
 package api
 
+import "time"
+
 type RegisterQueueRequest struct {
 	QueueID     QueueID      `json:"id"`
 	ResourceID  ResourceID   `json:"resource"`
@@ -20,5 +22,296 @@ func (r *RegisterQueueRequest) Validate() error {
 type EnqueueMessageRequest struct {
 	QueueID QueueID `json:"queue"`
 	Delay   Delay   `json:"delay"`
-	Data    string  `json:"data"`
+	// ScheduledAt is an absolute alternative to Delay.
+	ScheduledAt time.Time `json:"scheduled_at"`
+	// TTL counts from enqueue time, expired message is never delivered.
+	TTL Delay `json:"ttl"`
+	// ExpiresAt is an absolute alternative to TTL.
+	ExpiresAt  time.Time         `json:"expires_at"`
+	Data       string            `json:"data"`
+	Encoding   DataEncoding      `json:"encoding"`
+	Attributes MessageAttributes `json:"attributes"`
+	// DedupID makes enqueue idempotent within queue dedup window,
+	// repeated request returns MessageID of the first one.
+	DedupID string `json:"dedup_id"`
+}
+
+// maxDedupIDLength limits size of deduplication id.
+const maxDedupIDLength = 128
+
+func (r *EnqueueMessageRequest) Validate() error {
+	return Check(
+		Cb(r.Delay.Duration >= 0, "delay can not be negative"),
+		Cb(r.Delay.Duration == 0 || r.ScheduledAt.IsZero(), "delay and scheduled_at are mutually exclusive"),
+		Cb(r.TTL.Duration >= 0, "ttl can not be negative"),
+		Cb(r.TTL.Duration == 0 || r.ExpiresAt.IsZero(), "ttl and expires_at are mutually exclusive"),
+		Cb(len(r.DedupID) <= maxDedupIDLength, "dedup id can not be longer than %d bytes", maxDedupIDLength),
+		Ce(r.Encoding.Validate()),
+		Ce(r.Attributes.Validate()),
+	)
+}
+
+// ScheduleTime returns time when message becomes visible
+// for message enqueued at now.
+func (r *EnqueueMessageRequest) ScheduleTime(now time.Time) time.Time {
+	if !r.ScheduledAt.IsZero() {
+		return r.ScheduledAt
+	}
+	return now.Add(r.Delay.Duration)
+}
+
+// ExpiryTTL returns message TTL, defaultTTL is used when request sets
+// neither TTL nor ExpiresAt. Zero means that message expires at ExpiresAt
+// or never.
+func (r *EnqueueMessageRequest) ExpiryTTL(defaultTTL time.Duration) time.Duration {
+	if r.TTL.Duration == 0 && r.ExpiresAt.IsZero() {
+		return defaultTTL
+	}
+	return r.TTL.Duration
+}
+
+// ExpireTime returns time when message enqueued at now expires,
+// zero time means that message never expires.
+func (r *EnqueueMessageRequest) ExpireTime(now time.Time, defaultTTL time.Duration) time.Time {
+	if ttl := r.ExpiryTTL(defaultTTL); ttl != 0 {
+		return now.Add(ttl)
+	}
+	return r.ExpiresAt
+}
+
+// DecodeData turns data received as JSON into raw data.
+func (r *EnqueueMessageRequest) DecodeData() error {
+	if err := r.Encoding.Validate(); err != nil {
+		return err
+	}
+
+	data, err := decodeData(r.Data, r.Encoding)
+	if err != nil {
+		return err
+	}
+
+	r.Data, r.Encoding = data, PlainEncoding
+	return nil
+}
+
+// MaxBatchSize limits number of messages in batch requests.
+const MaxBatchSize = 1000
+
+type EnqueueBatchRequest struct {
+	QueueID QueueID `json:"queue"`
+	// Messages may omit queue, they are enqueued into QueueID.
+	Messages []EnqueueMessageRequest `json:"messages"`
+}
+
+func (r *EnqueueBatchRequest) Validate() error {
+	return Check(
+		Ce(r.QueueID.Validate()),
+		Cb(len(r.Messages) > 0, "batch can not be empty"),
+		Cb(len(r.Messages) <= MaxBatchSize, "batch can not be larger than %d messages", MaxBatchSize),
+	)
+}
+
+// EnqueueResult is an outcome of a single message enqueue in batch,
+// either ID or Error is set.
+type EnqueueResult struct {
+	ID    MessageID `json:"id,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+type AckBatchRequest struct {
+	QueueID QueueID  `json:"queue"`
+	AckKeys []string `json:"keys"`
+}
+
+func (r *AckBatchRequest) Validate() error {
+	return Check(
+		Ce(r.QueueID.Validate()),
+		Cb(len(r.AckKeys) > 0, "batch can not be empty"),
+		Cb(len(r.AckKeys) <= MaxBatchSize, "batch can not be larger than %d keys", MaxBatchSize),
+	)
+}
+
+const (
+	// DefaultListLimit is a page size of list requests without limit.
+	DefaultListLimit = 100
+	// MaxListLimit limits page size of list requests.
+	MaxListLimit = 1000
+)
+
+type ListQueuesRequest struct {
+	// After is a cursor, listing starts after queue with this id.
+	After QueueID `json:"after"`
+	Limit int     `json:"limit"`
+}
+
+func (r *ListQueuesRequest) Validate() error {
+	return Check(
+		Cb(r.After == "" || r.After.Validate() == nil, "after must be a queue id"),
+		Cb(r.Limit >= 0, "limit can not be negative"),
+		Cb(r.Limit <= MaxListLimit, "limit can not be larger than %d", MaxListLimit),
+	)
+}
+
+type ListQueuesResponse struct {
+	Queues []QueueMetadata `json:"queues"`
+	// Next is a cursor of the next page, it is empty on the last page.
+	Next QueueID `json:"next,omitempty"`
+}
+
+type ListResourcesRequest struct {
+	// After is a cursor, listing starts after resource with this id.
+	After ResourceID `json:"after"`
+	Limit int        `json:"limit"`
+}
+
+func (r *ListResourcesRequest) Validate() error {
+	return Check(
+		Cb(r.After == "" || r.After.Validate() == nil, "after must be a resource id"),
+		Cb(r.Limit >= 0, "limit can not be negative"),
+		Cb(r.Limit <= MaxListLimit, "limit can not be larger than %d", MaxListLimit),
+	)
+}
+
+type ListResourcesResponse struct {
+	// Resources have passwords in connection options redacted.
+	Resources []ResourceMetadata `json:"resources"`
+	// Next is a cursor of the next page, it is empty on the last page.
+	Next ResourceID `json:"next,omitempty"`
+}
+
+type DeleteResourceRequest struct {
+	ResourceID ResourceID `json:"id"`
+}
+
+type PeekRequest struct {
+	QueueID QueueID    `json:"queue"`
+	Filter  PeekFilter `json:"filter"`
+	// Cursor is a MessageID, messages after it are returned.
+	Cursor MessageID `json:"cursor"`
+	Limit  int       `json:"limit"`
+}
+
+func (r *PeekRequest) Validate() error {
+	return Check(
+		Ce(r.QueueID.Validate()),
+		Ce(r.Filter.State.Validate()),
+		Cb(r.Limit >= 0, "limit can not be negative"),
+		Cb(r.Limit <= MaxListLimit, "limit can not be larger than %d", MaxListLimit),
+	)
+}
+
+type PeekResponse struct {
+	Messages []PeekedMessage `json:"messages"`
+	// Next is a cursor of the next page, it is empty on the last page.
+	Next MessageID `json:"next,omitempty"`
+}
+
+type RescheduleRequest struct {
+	QueueID QueueID   `json:"queue"`
+	ID      MessageID `json:"id"`
+	Delay   Delay     `json:"delay"`
+	// ScheduledAt is an absolute alternative to Delay.
+	ScheduledAt time.Time `json:"scheduled_at"`
+}
+
+func (r *RescheduleRequest) Validate() error {
+	return Check(
+		Ce(r.QueueID.Validate()),
+		Cb(r.ID != "", "message id can not be empty"),
+		Cb(r.Delay.Duration >= 0, "delay can not be negative"),
+		Cb(r.Delay.Duration == 0 || r.ScheduledAt.IsZero(), "delay and scheduled_at are mutually exclusive"),
+	)
+}
+
+// ScheduleTime returns time when rescheduled message becomes visible.
+func (r *RescheduleRequest) ScheduleTime(now time.Time) time.Time {
+	if !r.ScheduledAt.IsZero() {
+		return r.ScheduledAt
+	}
+	return now.Add(r.Delay.Duration)
+}
+
+type DeleteQueueRequest struct {
+	QueueID QueueID `json:"id"`
+	// Force allows deletion of queue with messages.
+	Force bool `json:"force"`
+}
+
+func (r *DeleteQueueRequest) Validate() error {
+	return Check(
+		Ce(r.QueueID.Validate()),
+	)
+}
+
+// PurgeScope narrows purge to messages in particular state.
+type PurgeScope string
+
+const (
+	// PurgeAll - all messages including in flight ones.
+	PurgeAll PurgeScope = ""
+	// PurgeDelayed - messages which are not visible yet and were not
+	// delivered since the last enqueue or nack.
+	PurgeDelayed PurgeScope = "delayed"
+	// PurgeVisible - messages which can be polled right now.
+	PurgeVisible PurgeScope = "visible"
+)
+
+type PurgeRequest struct {
+	QueueID QueueID    `json:"id"`
+	Only    PurgeScope `json:"only"`
+	// ScheduledBefore limits purge to messages scheduled before it.
+	ScheduledBefore time.Time `json:"scheduled_before"`
+}
+
+func (r *PurgeRequest) Validate() error {
+	knownScope := false
+	switch r.Only {
+	case PurgeAll, PurgeDelayed, PurgeVisible:
+		knownScope = true
+	}
+
+	return Check(
+		Ce(r.QueueID.Validate()),
+		Cb(knownScope, "unknown purge scope %q", r.Only),
+	)
+}
+
+// Match reports whether message is purged by request.
+func (r *PurgeRequest) Match(now, scheduledAt, visibleAt time.Time, inFlight bool) bool {
+	if !r.ScheduledBefore.IsZero() && !scheduledAt.Before(r.ScheduledBefore) {
+		return false
+	}
+
+	switch r.Only {
+	case PurgeDelayed:
+		return visibleAt.After(now) && !inFlight
+	case PurgeVisible:
+		return !visibleAt.After(now)
+	}
+	return true
+}
+
+type RedriveRequest struct {
+	Source      QueueID       `json:"source"`
+	Destination QueueID       `json:"destination"`
+	Filter      MessageFilter `json:"filter"`
+	// Limit is a maximum number of messages to move, zero means all.
+	Limit     int `json:"limit"`
+	BatchSize int `json:"batch_size"`
+}
+
+func (r *RedriveRequest) Validate() error {
+	return Check(
+		Ce(r.Source.Validate()),
+		Ce(r.Destination.Validate()),
+		Cb(r.Source != r.Destination, "source and destination must differ"),
+		Cb(r.Limit >= 0, "limit can not be negative"),
+		Cb(r.BatchSize >= 0, "batch size can not be negative"),
+	)
+}
+
+type RedriveProgress struct {
+	Moved int    `json:"moved"`
+	Done  bool   `json:"done"`
+	Error string `json:"error,omitempty"`
 }
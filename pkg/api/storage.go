@@ -1,25 +1,77 @@
 package api
 
+import "time"
+
 // The Queue interface is implemented by objects that
 // represent queue
 type Queue interface {
 	Add(EnqueueMessageRequest) (MessageID, error)
+	// AddBatch enqueues all messages or none of them,
+	// returned ids are in the same order as messages.
+	AddBatch([]EnqueueMessageRequest) ([]MessageID, error)
+	// CheckMessage reports whether message can be stored by queue, so
+	// batch can reject single messages instead of failing entirely.
+	CheckMessage(EnqueueMessageRequest) error
 	Ack(ackKey string) error
+	// AckBatch acknowledges messages and returns keys which
+	// acknowledgement was ineffective for. Keys are applied as if they were
+	// acknowledged one by one, so repeated key is ineffective.
+	AckBatch(ackKeys []string) ([]string, error)
+	// Nack returns message to the queue, it becomes visible after delay,
+	// zero delay means queue retry policy backoff. Non-empty reason is
+	// stored and returned with the next delivery.
+	Nack(ackKey string, delay time.Duration, reason string) error
+	// ExtendVisibility keeps message invisible for visibility from now on,
+	// fails if message was redelivered with a new ack key.
+	ExtendVisibility(ackKey string, visibility time.Duration) error
 	Poll(PollRequest) ([]Message, error)
+	Stats() (QueueStats, error)
+	// Peek returns up to limit messages with ids greater than cursor
+	// ordered by id, messages are left intact. Empty cursor means
+	// the first page.
+	Peek(filter PeekFilter, cursor MessageID, limit int) ([]PeekedMessage, error)
+	// Get returns message without leasing it.
+	Get(MessageID) (PeekedMessage, error)
+	// Cancel removes message which is not in flight.
+	Cancel(MessageID) error
+	// Reschedule changes visibility time of message which is not in flight.
+	Reschedule(RescheduleRequest) error
 }
 
 // MetadataStorage defines behavior for configuration storage.
 type MetadataStorage interface {
 	RegisterQueueMetadata(RegisterQueueRequest) error
-	SetQueueState(QueueID, QueueState) error
+	// SetQueueState switches queue into state if transition is allowed,
+	// see QueueState.CanTransitionTo. Transition is recorded into queue
+	// history, not empty lastError is kept as queue LastError.
+	SetQueueState(qid QueueID, state QueueState, lastError string) error
+	// QueueHistory returns state transitions of queue, the oldest first.
+	QueueHistory(QueueID) ([]QueueTransition, error)
 	DeleteQueueMetadata(QueueID) error
 	GetQueueMetadata(qid QueueID, allowedStates ...QueueState) (QueueMetadata, error)
+	// ListQueues returns up to limit queues ordered by QueueID starting
+	// after given one, ConnOptions are not populated.
+	ListQueues(after QueueID, limit int) ([]QueueMetadata, error)
 	RegisterResource(ResourceMetadata) error
+	GetResource(ResourceID) (ResourceMetadata, error)
+	// ListResources returns up to limit resources ordered by ResourceID
+	// starting after given one.
+	ListResources(after ResourceID, limit int) ([]ResourceMetadata, error)
+	// UpdateResource replaces connection options of existing resource.
+	UpdateResource(ResourceMetadata) error
+	// DeleteResource fails if resource hosts any queue.
+	DeleteResource(ResourceID) error
 }
 
 // Connector is a factory for Backend creation.
 type Connector interface {
 	Connect(ResourceID, ResourceConnOptions) (Backend, error)
+	// Verify decodes options and checks that storage they point to
+	// is reachable and can host queues.
+	Verify(ResourceConnOptions) error
+	// Invalidate drops backend cached for resource, so the next Connect
+	// uses fresh connection options.
+	Invalidate(ResourceID)
 }
 
 type Manager interface {
@@ -28,6 +80,14 @@ type Manager interface {
 
 	// Connect to queue with QueueMetadata
 	ConnectToQueue(QueueMetadata) (Queue, error)
+
+	// DeleteQueue removes queue storage, it fails with ErrQueueNotEmpty
+	// if queue has messages and force is not set. Deletion of already
+	// deleted queue succeeds.
+	DeleteQueue(qm QueueMetadata, force bool) error
+
+	// PurgeQueue removes messages matching request and returns their number.
+	PurgeQueue(QueueMetadata, PurgeRequest) (int64, error)
 }
 
 // Backend exposes interface for managing queue objects.
@@ -2,29 +2,77 @@ package apis
 
 import (
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/palestamp/barnacle/pkg/api"
+	"github.com/palestamp/barnacle/pkg/metadata"
 )
 
 type V1APIService interface {
 	CreateQueue(api.RegisterQueueRequest) error
+	DeleteQueue(api.DeleteQueueRequest) error
+	ListQueues(api.ListQueuesRequest) (api.ListQueuesResponse, error)
+	GetQueue(api.QueueID) (api.QueueMetadata, error)
+	QueueStats(api.QueueID) (api.QueueStats, error)
+	PurgeQueue(api.PurgeRequest) (int64, error)
+	PauseQueue(api.QueueID) error
+	DrainQueue(api.QueueID) error
+	ResumeQueue(api.QueueID) error
+	QueueHistory(api.QueueID) ([]api.QueueTransition, error)
 	CreateMessage(api.EnqueueMessageRequest) (api.MessageID, error)
+	CreateMessages(api.EnqueueBatchRequest) ([]api.EnqueueResult, error)
 	AckMessage(api.QueueID, string) error
+	AckMessages(api.AckBatchRequest) ([]string, error)
+	NackMessage(qid api.QueueID, ackKey string, delay time.Duration, reason string) error
+	ExtendMessageVisibility(qid api.QueueID, ackKey string, visibility time.Duration) error
 	PollQueue(id api.QueueID, limit int, timeout, visibility time.Duration) ([]api.Message, error)
+	PeekMessages(api.PeekRequest) (api.PeekResponse, error)
+	GetMessage(api.QueueID, api.MessageID) (api.PeekedMessage, error)
+	CancelMessage(api.QueueID, api.MessageID) error
+	RescheduleMessage(api.RescheduleRequest) error
 	CreateResource(api.ResourceMetadata) error
+	GetResource(api.ResourceID) (api.ResourceMetadata, error)
+	ListResources(api.ListResourcesRequest) (api.ListResourcesResponse, error)
+	UpdateResource(api.ResourceMetadata) error
+	DeleteResource(api.DeleteResourceRequest) error
+	RedriveQueue(api.RedriveRequest, func(api.RedriveProgress)) (api.RedriveProgress, error)
 }
 
 func NewV1API(svc V1APIService) http.Handler {
 	s := &v1API{svc: svc}
 	mux := http.NewServeMux()
 	mux.Handle("/v1/queues.create", http.HandlerFunc(s.CreateQueue))
+	mux.Handle("/v1/queues.list", http.HandlerFunc(s.ListQueues))
+	mux.Handle("/v1/queues.info", http.HandlerFunc(s.QueueInfo))
+	mux.Handle("/v1/queues.stats", http.HandlerFunc(s.QueueStats))
+	mux.Handle("/v1/queues.delete", http.HandlerFunc(s.DeleteQueue))
+	mux.Handle("/v1/queues.purge", http.HandlerFunc(s.PurgeQueue))
+	mux.Handle("/v1/queues.pause", http.HandlerFunc(s.PauseQueue))
+	mux.Handle("/v1/queues.drain", http.HandlerFunc(s.DrainQueue))
+	mux.Handle("/v1/queues.resume", http.HandlerFunc(s.ResumeQueue))
+	mux.Handle("/v1/queues.history", http.HandlerFunc(s.QueueHistory))
+	mux.Handle("/v1/queues.redrive", http.HandlerFunc(s.RedriveQueue))
 	mux.Handle("/v1/messages.create", http.HandlerFunc(s.CreateMessage))
+	mux.Handle("/v1/messages.create_batch", http.HandlerFunc(s.CreateMessages))
 	mux.Handle("/v1/messages.poll", http.HandlerFunc(s.PollMessages))
+	mux.Handle("/v1/messages.peek", http.HandlerFunc(s.PeekMessages))
+	mux.Handle("/v1/messages.get", http.HandlerFunc(s.GetMessage))
+	mux.Handle("/v1/messages.cancel", http.HandlerFunc(s.CancelMessage))
+	mux.Handle("/v1/messages.reschedule", http.HandlerFunc(s.RescheduleMessage))
 	mux.Handle("/v1/messages.ack", http.HandlerFunc(s.AckMessage))
+	mux.Handle("/v1/messages.ack_batch", http.HandlerFunc(s.AckMessages))
+	mux.Handle("/v1/messages.nack", http.HandlerFunc(s.NackMessage))
+	mux.Handle("/v1/messages.extend", http.HandlerFunc(s.ExtendMessage))
 	mux.Handle("/v1/resources.create", http.HandlerFunc(s.CreateResource))
+	mux.Handle("/v1/resources.list", http.HandlerFunc(s.ListResources))
+	mux.Handle("/v1/resources.info", http.HandlerFunc(s.ResourceInfo))
+	mux.Handle("/v1/resources.update", http.HandlerFunc(s.UpdateResource))
+	mux.Handle("/v1/resources.delete", http.HandlerFunc(s.DeleteResource))
 	return mux
 }
 
@@ -44,17 +92,263 @@ func (s *v1API) CreateQueue(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *v1API) ListQueues(w http.ResponseWriter, r *http.Request) {
+	qp := r.URL.Query()
+
+	// malformed limit falls back to default page size
+	limit, _ := strconv.Atoi(qp.Get("limit"))
+	resp, err := s.svc.ListQueues(api.ListQueuesRequest{
+		After: api.QueueID(qp.Get("after")),
+		Limit: limit,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *v1API) QueueInfo(w http.ResponseWriter, r *http.Request) {
+	qm, err := s.svc.GetQueue(api.QueueID(r.URL.Query().Get("id")))
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	json.NewEncoder(w).Encode(qm)
+}
+
+func (s *v1API) QueueStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := s.svc.QueueStats(api.QueueID(r.URL.Query().Get("id")))
+	if err != nil {
+		http.Error(w, err.Error(), queueErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (s *v1API) DeleteQueue(w http.ResponseWriter, r *http.Request) {
+	var dqr api.DeleteQueueRequest
+	if err := json.NewDecoder(r.Body).Decode(&dqr); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.svc.DeleteQueue(dqr); err != nil {
+		code := 500
+		switch errors.Cause(err) {
+		case api.ErrQueueNotEmpty, api.ErrQueueReferenced:
+			code = 409
+		}
+		http.Error(w, err.Error(), code)
+	}
+}
+
+func (s *v1API) PurgeQueue(w http.ResponseWriter, r *http.Request) {
+	var pr api.PurgeRequest
+	if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	purged, err := s.svc.PurgeQueue(pr)
+	if err != nil {
+		http.Error(w, err.Error(), queueErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Purged int64 `json:"purged"`
+	}{
+		Purged: purged,
+	})
+}
+
+// PauseQueue stops delivery of queue messages, poll of paused queue
+// fails with 423 Locked.
+func (s *v1API) PauseQueue(w http.ResponseWriter, r *http.Request) {
+	var req queueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.svc.PauseQueue(req.QueueID); err != nil {
+		http.Error(w, err.Error(), queueErrorStatus(err))
+		return
+	}
+}
+
+// DrainQueue stops acceptance of new messages, enqueue into draining
+// queue fails with 423 Locked.
+func (s *v1API) DrainQueue(w http.ResponseWriter, r *http.Request) {
+	var req queueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.svc.DrainQueue(req.QueueID); err != nil {
+		http.Error(w, err.Error(), queueErrorStatus(err))
+		return
+	}
+}
+
+// ResumeQueue returns paused or draining queue into service.
+func (s *v1API) ResumeQueue(w http.ResponseWriter, r *http.Request) {
+	var req queueRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.svc.ResumeQueue(req.QueueID); err != nil {
+		http.Error(w, err.Error(), queueErrorStatus(err))
+		return
+	}
+}
+
+func (s *v1API) QueueHistory(w http.ResponseWriter, r *http.Request) {
+	history, err := s.svc.QueueHistory(api.QueueID(r.URL.Query().Get("id")))
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Transitions []api.QueueTransition `json:"transitions"`
+	}{
+		Transitions: history,
+	})
+}
+
+// queueErrorStatus maps errors caused by queue state.
+func queueErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case metadata.ErrQueueNotFound:
+		return 404
+	case api.ErrQueuePaused, api.ErrQueueDraining:
+		return 423
+	case api.ErrQueueStateTransition:
+		return 409
+	}
+	return 500
+}
+
+// queueRequest is a body of requests which address queue by id only.
+type queueRequest struct {
+	QueueID api.QueueID `json:"id"`
+}
+
+// RedriveQueue streams redrive progress as newline delimited JSON objects,
+// the last object has either "done" or "error" field set.
+func (s *v1API) RedriveQueue(w http.ResponseWriter, r *http.Request) {
+	var rr api.RedriveRequest
+	if err := json.NewDecoder(r.Body).Decode(&rr); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	enc := json.NewEncoder(w)
+	written := false
+	report := func(p api.RedriveProgress) {
+		if !written {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			written = true
+		}
+		enc.Encode(p)
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+
+	p, err := s.svc.RedriveQueue(rr, report)
+	if err != nil {
+		if !written {
+			http.Error(w, err.Error(), 500)
+			return
+		}
+		p.Error = err.Error()
+	}
+	report(p)
+}
+
+// maxRawMessageSize limits body of application/octet-stream enqueue requests.
+const maxRawMessageSize = 1 << 20
+
+// CreateMessage accepts either JSON EnqueueMessageRequest or raw message data
+// with application/octet-stream content type, in the latter case queue,
+// delay, scheduled_at, ttl, expires_at, dedup_id and JSON encoded attributes
+// are passed as query parameters.
 func (s *v1API) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	var emr api.EnqueueMessageRequest
-	if err := json.NewDecoder(r.Body).Decode(&emr); err != nil {
+	defer r.Body.Close()
+
+	if r.Header.Get("Content-Type") == "application/octet-stream" {
+		qp := r.URL.Query()
+
+		queue := qp.Get("queue")
+		if queue == "" {
+			http.Error(w, "queue must be set", 422)
+			return
+		}
+
+		data, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxRawMessageSize))
+		if err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+
+		if v := qp.Get("scheduled_at"); v != "" {
+			if emr.ScheduledAt, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		}
+
+		if emr.Delay.Duration, err = parseDuration(qp.Get("delay"), 0); err != nil {
+			http.Error(w, "delay: "+err.Error(), 422)
+			return
+		}
+
+		if v := qp.Get("expires_at"); v != "" {
+			if emr.ExpiresAt, err = time.Parse(time.RFC3339, v); err != nil {
+				http.Error(w, err.Error(), 400)
+				return
+			}
+		}
+
+		if emr.TTL.Duration, err = parseDuration(qp.Get("ttl"), 0); err != nil {
+			http.Error(w, "ttl: "+err.Error(), 422)
+			return
+		}
+
+		if v := qp.Get("attributes"); v != "" {
+			if err = json.Unmarshal([]byte(v), &emr.Attributes); err != nil {
+				http.Error(w, "attributes: "+err.Error(), 400)
+				return
+			}
+		}
+
+		emr.QueueID = api.QueueID(queue)
+		emr.DedupID = qp.Get("dedup_id")
+		emr.Data = string(data)
+	} else if err := json.NewDecoder(r.Body).Decode(&emr); err != nil {
 		http.Error(w, err.Error(), 400)
 		return
 	}
-	defer r.Body.Close()
 
 	messageID, err := s.svc.CreateMessage(emr)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), queueErrorStatus(err))
 		return
 	}
 
@@ -65,6 +359,27 @@ func (s *v1API) CreateMessage(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+func (s *v1API) CreateMessages(w http.ResponseWriter, r *http.Request) {
+	var ebr api.EnqueueBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&ebr); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	results, err := s.svc.CreateMessages(ebr)
+	if err != nil {
+		http.Error(w, err.Error(), queueErrorStatus(err))
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Results []api.EnqueueResult `json:"results"`
+	}{
+		Results: results,
+	})
+}
+
 func (s *v1API) AckMessage(w http.ResponseWriter, r *http.Request) {
 	qp := r.URL.Query()
 
@@ -87,6 +402,179 @@ func (s *v1API) AckMessage(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (s *v1API) AckMessages(w http.ResponseWriter, r *http.Request) {
+	var abr api.AckBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&abr); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	ineffective, err := s.svc.AckMessages(abr)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	json.NewEncoder(w).Encode(struct {
+		Ineffective []string `json:"ineffective"`
+	}{
+		Ineffective: ineffective,
+	})
+}
+
+func (s *v1API) NackMessage(w http.ResponseWriter, r *http.Request) {
+	qp := r.URL.Query()
+
+	ackKey := qp.Get("key")
+	if ackKey == "" {
+		http.Error(w, "ackKey must be set", 422)
+		return
+	}
+
+	queue := qp.Get("queue")
+	if queue == "" {
+		http.Error(w, "queue must be set", 422)
+		return
+	}
+
+	delay, err := parseDuration(qp.Get("delay"), 0)
+	if err != nil {
+		http.Error(w, "delay: "+err.Error(), 422)
+		return
+	}
+
+	err = s.svc.NackMessage(api.QueueID(queue), ackKey, delay, qp.Get("reason"))
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+func (s *v1API) ExtendMessage(w http.ResponseWriter, r *http.Request) {
+	qp := r.URL.Query()
+
+	ackKey := qp.Get("key")
+	if ackKey == "" {
+		http.Error(w, "ackKey must be set", 422)
+		return
+	}
+
+	queue := qp.Get("queue")
+	if queue == "" {
+		http.Error(w, "queue must be set", 422)
+		return
+	}
+
+	visibility, err := parseDuration(qp.Get("visibility"), time.Minute)
+	if err != nil {
+		http.Error(w, "visibility: "+err.Error(), 422)
+		return
+	}
+
+	err = s.svc.ExtendMessageVisibility(api.QueueID(queue), ackKey, visibility)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+}
+
+// PeekMessages accepts PeekRequest as JSON body, data encoding of returned
+// messages is controlled by encoding query parameter the same way as in poll.
+func (s *v1API) PeekMessages(w http.ResponseWriter, r *http.Request) {
+	var pr api.PeekRequest
+	if err := json.NewDecoder(r.Body).Decode(&pr); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	encoding := api.DataEncoding(r.URL.Query().Get("encoding"))
+	if err := encoding.Validate(); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	resp, err := s.svc.PeekMessages(pr)
+	if err != nil {
+		http.Error(w, err.Error(), 500)
+		return
+	}
+
+	for i := range resp.Messages {
+		resp.Messages[i].EncodeData(encoding)
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *v1API) GetMessage(w http.ResponseWriter, r *http.Request) {
+	qp := r.URL.Query()
+
+	encoding := api.DataEncoding(qp.Get("encoding"))
+	if err := encoding.Validate(); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	msg, err := s.svc.GetMessage(api.QueueID(qp.Get("queue")), api.MessageID(qp.Get("id")))
+	if err != nil {
+		http.Error(w, err.Error(), messageErrorStatus(err))
+		return
+	}
+
+	msg.EncodeData(encoding)
+	json.NewEncoder(w).Encode(msg)
+}
+
+func (s *v1API) CancelMessage(w http.ResponseWriter, r *http.Request) {
+	qp := r.URL.Query()
+
+	err := s.svc.CancelMessage(api.QueueID(qp.Get("queue")), api.MessageID(qp.Get("id")))
+	if err != nil {
+		http.Error(w, err.Error(), messageErrorStatus(err))
+		return
+	}
+}
+
+func (s *v1API) RescheduleMessage(w http.ResponseWriter, r *http.Request) {
+	qp := r.URL.Query()
+
+	rr := api.RescheduleRequest{
+		QueueID: api.QueueID(qp.Get("queue")),
+		ID:      api.MessageID(qp.Get("id")),
+	}
+
+	var err error
+	if rr.Delay.Duration, err = parseDuration(qp.Get("delay"), 0); err != nil {
+		http.Error(w, "delay: "+err.Error(), 422)
+		return
+	}
+
+	if v := qp.Get("scheduled_at"); v != "" {
+		if rr.ScheduledAt, err = time.Parse(time.RFC3339, v); err != nil {
+			http.Error(w, err.Error(), 400)
+			return
+		}
+	}
+
+	if err := s.svc.RescheduleMessage(rr); err != nil {
+		http.Error(w, err.Error(), messageErrorStatus(err))
+		return
+	}
+}
+
+// messageErrorStatus maps errors of operations on a single message.
+func messageErrorStatus(err error) int {
+	switch errors.Cause(err) {
+	case api.ErrMessageNotFound, metadata.ErrQueueNotFound:
+		return 404
+	case api.ErrMessageInFlight:
+		return 409
+	}
+	return 500
+}
+
 func (s *v1API) PollMessages(w http.ResponseWriter, r *http.Request) {
 	qp := r.URL.Query()
 
@@ -108,15 +596,34 @@ func (s *v1API) PollMessages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	timeout := parseSeconds(qp.Get("timeout"), time.Second)
-	visibility := parseSeconds(qp.Get("visibility"), time.Minute)
+	timeout, err := parseDuration(qp.Get("timeout"), time.Second)
+	if err != nil {
+		http.Error(w, "timeout: "+err.Error(), 422)
+		return
+	}
+
+	visibility, err := parseDuration(qp.Get("visibility"), time.Minute)
+	if err != nil {
+		http.Error(w, "visibility: "+err.Error(), 422)
+		return
+	}
+
+	encoding := api.DataEncoding(qp.Get("encoding"))
+	if err := encoding.Validate(); err != nil {
+		http.Error(w, err.Error(), 422)
+		return
+	}
 
 	mgs, err := s.svc.PollQueue(api.QueueID(queue), l, timeout, visibility)
 	if err != nil {
-		http.Error(w, err.Error(), 500)
+		http.Error(w, err.Error(), queueErrorStatus(err))
 		return
 	}
 
+	for i := range mgs {
+		mgs[i].EncodeData(encoding)
+	}
+
 	json.NewEncoder(w).Encode(struct {
 		Messages []api.Message `json:"messages"`
 	}{
@@ -136,10 +643,75 @@ func (s *v1API) CreateResource(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func parseSeconds(s string, def time.Duration) time.Duration {
-	v, err := strconv.Atoi(s)
+func (s *v1API) ListResources(w http.ResponseWriter, r *http.Request) {
+	qp := r.URL.Query()
+
+	// malformed limit falls back to default page size
+	limit, _ := strconv.Atoi(qp.Get("limit"))
+	resp, err := s.svc.ListResources(api.ListResourcesRequest{
+		After: api.ResourceID(qp.Get("after")),
+		Limit: limit,
+	})
 	if err != nil {
-		return def
+		http.Error(w, err.Error(), 422)
+		return
+	}
+
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (s *v1API) ResourceInfo(w http.ResponseWriter, r *http.Request) {
+	rm, err := s.svc.GetResource(api.ResourceID(r.URL.Query().Get("id")))
+	if err != nil {
+		http.Error(w, err.Error(), 404)
+		return
+	}
+
+	json.NewEncoder(w).Encode(rm)
+}
+
+func (s *v1API) UpdateResource(w http.ResponseWriter, r *http.Request) {
+	var m api.ResourceMetadata
+	if err := json.NewDecoder(r.Body).Decode(&m); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.svc.UpdateResource(m); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+func (s *v1API) DeleteResource(w http.ResponseWriter, r *http.Request) {
+	var drr api.DeleteResourceRequest
+	if err := json.NewDecoder(r.Body).Decode(&drr); err != nil {
+		http.Error(w, err.Error(), 400)
+		return
+	}
+	defer r.Body.Close()
+
+	if err := s.svc.DeleteResource(drr); err != nil {
+		http.Error(w, err.Error(), 500)
+	}
+}
+
+// parseDuration accepts Go time.Duration notation or integer number of
+// seconds, def is returned for empty value.
+func parseDuration(s string, def time.Duration) (time.Duration, error) {
+	if s == "" {
+		return def, nil
+	}
+
+	var d time.Duration
+	if v, err := strconv.Atoi(s); err == nil {
+		d = time.Second * time.Duration(v)
+	} else if d, err = time.ParseDuration(s); err != nil {
+		return 0, errors.Errorf("invalid duration %q", s)
+	}
+
+	if d < 0 {
+		return 0, errors.Errorf("duration %q can not be negative", s)
 	}
-	return time.Second * time.Duration(v)
+	return d, nil
 }
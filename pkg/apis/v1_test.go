@@ -0,0 +1,156 @@
+package apis
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/palestamp/barnacle/pkg/api"
+	"github.com/palestamp/barnacle/pkg/backends"
+	"github.com/palestamp/barnacle/pkg/backends/memory"
+	"github.com/palestamp/barnacle/pkg/metadata"
+	"github.com/palestamp/barnacle/pkg/service"
+)
+
+// newHandler returns v1 API backed by memory queue erebor.
+func newHandler(t *testing.T) http.Handler {
+	registry := backends.NewRegistry()
+	registry.RegisterConnector(api.BackendType("memory"), memory.NewConnector())
+
+	svc := service.New(registry, metadata.NewMemoryStorage())
+	if err := svc.CreateResource(api.ResourceMetadata{ResourceID: "main", BackendType: "memory"}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := svc.CreateQueue(api.RegisterQueueRequest{
+		QueueID:     "erebor",
+		ResourceID:  "main",
+		BackendType: "memory",
+		QueueType:   api.SimpleDelayQueue,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewV1API(svc)
+}
+
+func do(h http.Handler, method, target, contentType, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if contentType != "" {
+		r.Header.Set("Content-Type", contentType)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestParseDuration(t *testing.T) {
+	def := 42 * time.Second
+	cases := []struct {
+		in  string
+		out time.Duration
+		err bool
+	}{
+		{in: "500ms", out: 500 * time.Millisecond},
+		{in: "1.9s", out: 1900 * time.Millisecond},
+		{in: "2m30s", out: 150 * time.Second},
+		// bare integers are seconds for backward compatibility
+		{in: "2", out: 2 * time.Second},
+		{in: "0", out: 0},
+		{in: "", out: def},
+		{in: "soon", err: true},
+		{in: "1.9", err: true},
+		{in: "-2", err: true},
+		{in: "-500ms", err: true},
+	}
+
+	for _, c := range cases {
+		d, err := parseDuration(c.in, def)
+		if c.err {
+			assert.Error(t, err, c.in)
+			continue
+		}
+
+		assert.NoError(t, err, c.in)
+		assert.Equal(t, c.out, d, c.in)
+	}
+}
+
+func TestMessageErrorStatus(t *testing.T) {
+	assert.Equal(t, 404, messageErrorStatus(api.ErrMessageNotFound))
+	assert.Equal(t, 404, messageErrorStatus(errors.Wrap(api.ErrMessageNotFound, "get failed")))
+	assert.Equal(t, 404, messageErrorStatus(metadata.ErrQueueNotFound))
+	assert.Equal(t, 409, messageErrorStatus(errors.Wrap(api.ErrMessageInFlight, "cancel failed")))
+	assert.Equal(t, 500, messageErrorStatus(errors.New("connection refused")))
+}
+
+func TestDurationParametersRejected(t *testing.T) {
+	h := newHandler(t)
+
+	for _, target := range []string{
+		"/v1/messages.poll?queue=erebor&limit=1&timeout=1.9",
+		"/v1/messages.poll?queue=erebor&limit=1&visibility=-1",
+		"/v1/messages.nack?queue=erebor&key=1/abc&delay=-5s",
+		"/v1/messages.extend?queue=erebor&key=1/abc&visibility=soon",
+		"/v1/messages.create?queue=erebor&delay=-5s",
+	} {
+		w := do(h, "POST", target, "application/octet-stream", "smaug")
+		assert.Equal(t, 422, w.Code, target)
+	}
+}
+
+func TestCreateRawMessageQueryParameters(t *testing.T) {
+	h := newHandler(t)
+
+	target := "/v1/messages.create?queue=erebor&dedup_id=arkenstone&ttl=1h" +
+		"&attributes=%7B%22kind%22%3A%22gem%22%7D"
+
+	var first, second struct {
+		ID api.MessageID `json:"id"`
+	}
+	w := do(h, "POST", target, "application/octet-stream", "smaug")
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&first))
+
+	w = do(h, "POST", target, "application/octet-stream", "smaug")
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&second))
+	assert.Equal(t, first.ID, second.ID)
+
+	var resp struct {
+		Messages []api.Message `json:"messages"`
+	}
+	w = do(h, "POST", "/v1/messages.poll?queue=erebor&limit=10&timeout=1s", "", "")
+	assert.Equal(t, 200, w.Code, w.Body.String())
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	if assert.Len(t, resp.Messages, 1) {
+		assert.Equal(t, "smaug", resp.Messages[0].Data)
+		assert.Equal(t, "gem", resp.Messages[0].Attributes["kind"])
+		assert.NotNil(t, resp.Messages[0].ExpiresAt)
+	}
+
+	w = do(h, "POST", "/v1/messages.create?queue=erebor&ttl=-1h", "application/octet-stream", "smaug")
+	assert.Equal(t, 422, w.Code)
+
+	w = do(h, "POST", "/v1/messages.create?queue=erebor&attributes=gem", "application/octet-stream", "smaug")
+	assert.Equal(t, 400, w.Code)
+}
+
+func TestQueueNotFound(t *testing.T) {
+	h := newHandler(t)
+
+	w := do(h, "GET", "/v1/queues.stats?id=mordor", "", "")
+	assert.Equal(t, 404, w.Code)
+
+	w = do(h, "POST", "/v1/queues.purge", "application/json", `{"id": "mordor"}`)
+	assert.Equal(t, 404, w.Code)
+
+	w = do(h, "GET", "/v1/queues.stats?id=erebor", "", "")
+	assert.Equal(t, 200, w.Code)
+}
@@ -0,0 +1,48 @@
+package memory
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/palestamp/barnacle/pkg/api"
+)
+
+var (
+	ErrUnknownQueueType = errors.New("unknown queue type")
+
+	queueTypes = map[api.QueueType]managerInitializer{
+		api.SimpleDelayQueue: NewDelayQueueManager,
+	}
+)
+
+type managerInitializer func(*MemoryBackend) (api.Manager, error)
+
+// MemoryBackend keeps queues of a single resource in process memory.
+// All queues of a backend share one lock.
+type MemoryBackend struct {
+	mu     sync.Mutex
+	queues map[api.QueueID]*delayQueueStorage
+}
+
+func NewBackend() *MemoryBackend {
+	return &MemoryBackend{
+		queues: make(map[api.QueueID]*delayQueueStorage),
+	}
+}
+
+func (s *MemoryBackend) GetQueueManager(qt api.QueueType) (api.Manager, error) {
+	queueManagerCreator, ok := queueTypes[qt]
+	if !ok {
+		return nil, ErrUnknownQueueType
+	}
+	return queueManagerCreator(s)
+}
+
+// storage must be called with backend lock held.
+func (s *MemoryBackend) storage(qid api.QueueID) (*delayQueueStorage, error) {
+	st, ok := s.queues[qid]
+	if !ok {
+		return nil, ErrQueueNotFound
+	}
+	return st, nil
+}
@@ -0,0 +1,67 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/palestamp/barnacle/pkg/api"
+	"github.com/palestamp/barnacle/pkg/machinery/decode"
+)
+
+// ResourceConnOptions is empty: memory resources hold data in-process
+// and do not need any connection parameters.
+type ResourceConnOptions struct{}
+
+func NewConnector() api.Connector {
+	return &connector{
+		backends: make(map[api.ResourceID]*MemoryBackend),
+	}
+}
+
+type connector struct {
+	mu       sync.Mutex
+	backends map[api.ResourceID]*MemoryBackend
+}
+
+func (c *connector) Connect(rid api.ResourceID, ops api.ResourceConnOptions) (api.Backend, error) {
+	var op ResourceConnOptions
+	if err := decode.Decode(ops, &op); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Backend holds all queue data of a resource, so it must live as long
+	// as the connector does.
+	backend, ok := c.backends[rid]
+	if !ok {
+		backend = NewBackend()
+		c.backends[rid] = backend
+	}
+	return backend, nil
+}
+
+func (c *connector) Verify(ops api.ResourceConnOptions) error {
+	var op ResourceConnOptions
+	return decode.Decode(ops, &op)
+}
+
+// Invalidate forgets backend of resource only if it holds no queues,
+// because backend is the only copy of queue data.
+func (c *connector) Invalidate(rid api.ResourceID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	backend, ok := c.backends[rid]
+	if !ok {
+		return
+	}
+
+	backend.mu.Lock()
+	empty := len(backend.queues) == 0
+	backend.mu.Unlock()
+
+	if empty {
+		delete(c.backends, rid)
+	}
+}
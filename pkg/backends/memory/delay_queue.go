@@ -0,0 +1,738 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/palestamp/barnacle/pkg/api"
+	"github.com/palestamp/barnacle/pkg/machinery/decode"
+)
+
+var (
+	ErrQueueExists               = errors.New("queue already exists")
+	ErrQueueNotFound             = errors.New("queue not found")
+	ErrDeadLetterQueueUnresolved = errors.New("dead letter queue metadata not resolved")
+	ErrExpiryQueueUnresolved     = errors.New("expiry queue metadata not resolved")
+)
+
+func NewDelayQueueManager(backend *MemoryBackend) (api.Manager, error) {
+	return &delayQueueManager{backend: backend}, nil
+}
+
+type delayQueueManager struct {
+	backend *MemoryBackend
+}
+
+type delayQueueOptions struct {
+	api.DeliveryOptions `mapstructure:",squash"`
+}
+
+func (s *delayQueueManager) decodeOpts(qm api.QueueOptions) (delayQueueOptions, error) {
+	var ops delayQueueOptions
+	if err := decode.Decode(qm, &ops); err != nil {
+		return ops, err
+	}
+
+	err := ops.DeliveryOptions.Validate()
+	return ops, err
+}
+
+func (s *delayQueueManager) CreateQueue(rqr api.RegisterQueueRequest) error {
+	if _, err := s.decodeOpts(rqr.Options); err != nil {
+		return err
+	}
+
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	if _, ok := s.backend.queues[rqr.QueueID]; ok {
+		return ErrQueueExists
+	}
+
+	s.backend.queues[rqr.QueueID] = newDelayQueueStorage()
+	return nil
+}
+
+func (s *delayQueueManager) DeleteQueue(qm api.QueueMetadata, force bool) error {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	st, ok := s.backend.queues[qm.QueueID]
+	if !ok {
+		return nil
+	}
+
+	if !force && len(st.messages) > 0 {
+		return api.ErrQueueNotEmpty
+	}
+
+	delete(s.backend.queues, qm.QueueID)
+	return nil
+}
+
+func (s *delayQueueManager) PurgeQueue(qm api.QueueMetadata, pr api.PurgeRequest) (int64, error) {
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	st, err := s.backend.storage(qm.QueueID)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	var purged int64
+	for id, m := range st.messages {
+		if pr.Match(now, m.scheduledAt, m.visibleAt, m.ackToken != "") {
+			delete(st.messages, id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+func (s *delayQueueManager) ConnectToQueue(qm api.QueueMetadata) (api.Queue, error) {
+	ops, err := s.decodeOpts(qm.Options)
+	if err != nil {
+		return nil, err
+	}
+
+	if ops.DeadLetterQueue != "" {
+		if _, ok := qm.References[ops.DeadLetterQueue]; !ok {
+			return nil, ErrDeadLetterQueueUnresolved
+		}
+	}
+
+	if ops.ExpiryQueue != "" {
+		if _, ok := qm.References[ops.ExpiryQueue]; !ok {
+			return nil, ErrExpiryQueueUnresolved
+		}
+	}
+
+	s.backend.mu.Lock()
+	defer s.backend.mu.Unlock()
+
+	if _, ok := s.backend.queues[qm.QueueID]; !ok {
+		return nil, ErrQueueNotFound
+	}
+
+	return &simpleDelayQueue{
+		backend:  s.backend,
+		queueID:  qm.QueueID,
+		delivery: ops.DeliveryOptions,
+	}, nil
+}
+
+// message mirrors a row of postgres simple-delay queue table.
+type message struct {
+	id          int64
+	createdAt   time.Time
+	scheduledAt time.Time
+	visibleAt   time.Time
+	expiresAt   time.Time
+	ackToken    string
+	attempts    int
+	data        string
+	attributes  api.MessageAttributes
+	lastError   string
+}
+
+// state mirrors message state expression of postgres peek.
+func (m *message) state(now time.Time) api.MessageState {
+	switch {
+	case m.visibleAt.After(now) && m.ackToken != "":
+		return api.InFlightMessageState
+	case !m.expiresAt.IsZero() && !m.expiresAt.After(now):
+		return api.ExpiredMessageState
+	case m.visibleAt.After(now):
+		return api.DelayedMessageState
+	}
+	return api.VisibleMessageState
+}
+
+// dedupEntry mirrors a row of postgres dedup table.
+type dedupEntry struct {
+	messageID int64
+	createdAt time.Time
+}
+
+type delayQueueStorage struct {
+	lastID   int64
+	messages map[int64]*message
+	dedup    map[string]dedupEntry
+	// expired counts messages removed from queue after expiration.
+	expired int64
+}
+
+func newDelayQueueStorage() *delayQueueStorage {
+	return &delayQueueStorage{
+		messages: make(map[int64]*message),
+		dedup:    make(map[string]dedupEntry),
+	}
+}
+
+func (st *delayQueueStorage) add(m *message) int64 {
+	st.lastID++
+	m.id = st.lastID
+	st.messages[m.id] = m
+	return m.id
+}
+
+// visible returns messages visible at now, oldest first.
+func (st *delayQueueStorage) visible(now time.Time) []*message {
+	out := make([]*message, 0)
+	for _, m := range st.messages {
+		if !m.visibleAt.After(now) {
+			out = append(out, m)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].visibleAt.Equal(out[j].visibleAt) {
+			return out[i].id < out[j].id
+		}
+		return out[i].visibleAt.Before(out[j].visibleAt)
+	})
+	return out
+}
+
+type simpleDelayQueue struct {
+	backend  *MemoryBackend
+	queueID  api.QueueID
+	delivery api.DeliveryOptions
+}
+
+// storage must be called with backend lock held.
+func (t *simpleDelayQueue) storage() (*delayQueueStorage, error) {
+	return t.backend.storage(t.queueID)
+}
+
+func (t *simpleDelayQueue) Poll(pr api.PollRequest) ([]api.Message, error) {
+	now := time.Now()
+	if !pr.Deadline.IsZero() && now.After(pr.Deadline) {
+		return nil, context.DeadlineExceeded
+	}
+
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := t.expire(st, now); err != nil {
+		return nil, err
+	}
+
+	if t.delivery.MaxAttempts > 0 {
+		if err := t.moveDeadLetters(st, now, pr.Limit); err != nil {
+			return nil, err
+		}
+	}
+
+	out := make([]api.Message, 0, pr.Limit)
+	for _, m := range st.visible(now) {
+		if len(out) >= pr.Limit {
+			break
+		}
+
+		// messages left for the next dead letter move
+		if t.delivery.MaxAttempts > 0 && m.attempts >= t.delivery.MaxAttempts {
+			continue
+		}
+
+		if !pr.Filter.Match(m.createdAt) {
+			continue
+		}
+
+		m.attempts++
+		m.visibleAt = now.Add(pr.Visibility + t.delivery.RetryBackoff(m.attempts))
+		m.ackToken = newAckToken()
+
+		out = append(out, api.Message{
+			ID:          formatMessageID(m.id),
+			CreatedAt:   m.createdAt,
+			ScheduledAt: m.scheduledAt,
+			Data:        m.data,
+			ExpiresAt:   nullTime(m.expiresAt),
+			Attributes:  copyAttributes(m.attributes),
+			AckKey:      formatAckKey(m.id, m.ackToken),
+			LastError:   m.lastError,
+		})
+	}
+
+	return out, nil
+}
+
+// expire removes expired messages which are not in flight, they are moved
+// into expiry queue if it is configured. Must be called with backend lock held.
+func (t *simpleDelayQueue) expire(st *delayQueueStorage, now time.Time) error {
+	var eq *delayQueueStorage
+	if t.delivery.ExpiryQueue != "" {
+		var err error
+		if eq, err = t.backend.storage(t.delivery.ExpiryQueue); err != nil {
+			return err
+		}
+	}
+
+	for id, m := range st.messages {
+		if m.expiresAt.IsZero() || m.expiresAt.After(now) {
+			continue
+		}
+
+		// in flight message is expired after visibility timeout
+		if m.ackToken != "" && m.visibleAt.After(now) {
+			continue
+		}
+
+		delete(st.messages, id)
+		st.expired++
+
+		if eq != nil {
+			eq.add(&message{
+				createdAt:   m.createdAt,
+				scheduledAt: m.scheduledAt,
+				visibleAt:   now,
+				data:        m.data,
+				attributes:  m.attributes,
+				lastError:   m.lastError,
+			})
+		}
+	}
+	return nil
+}
+
+// moveDeadLetters moves up to limit visible messages which reached
+// max attempts into dead letter queue. Must be called with backend lock held.
+func (t *simpleDelayQueue) moveDeadLetters(st *delayQueueStorage, now time.Time, limit int) error {
+	dlq, err := t.backend.storage(t.delivery.DeadLetterQueue)
+	if err != nil {
+		return err
+	}
+
+	moved := 0
+	for _, m := range st.visible(now) {
+		if moved >= limit {
+			break
+		}
+
+		if m.attempts < t.delivery.MaxAttempts {
+			continue
+		}
+
+		delete(st.messages, m.id)
+		dlq.add(&message{
+			createdAt:   m.createdAt,
+			scheduledAt: m.scheduledAt,
+			visibleAt:   now,
+			data:        m.data,
+			attributes:  m.attributes,
+			lastError:   m.lastError,
+		})
+		moved++
+	}
+	return nil
+}
+
+func (t *simpleDelayQueue) Add(emr api.EnqueueMessageRequest) (api.MessageID, error) {
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return "", err
+	}
+
+	return formatMessageID(t.insert(st, emr, time.Now())), nil
+}
+
+// insert adds message unless message with the same dedup id was
+// inserted within dedup window, id of that message is returned instead.
+// Must be called with backend lock held.
+func (t *simpleDelayQueue) insert(st *delayQueueStorage, emr api.EnqueueMessageRequest, now time.Time) int64 {
+	if emr.DedupID != "" {
+		window := t.delivery.DeduplicationWindow()
+		if e, ok := st.dedup[emr.DedupID]; ok && now.Sub(e.createdAt) < window {
+			return e.messageID
+		}
+
+		for k, e := range st.dedup {
+			if now.Sub(e.createdAt) >= window {
+				delete(st.dedup, k)
+			}
+		}
+	}
+
+	scheduledAt := emr.ScheduleTime(now)
+	id := st.add(&message{
+		createdAt:   now,
+		scheduledAt: scheduledAt,
+		visibleAt:   scheduledAt,
+		expiresAt:   emr.ExpireTime(now, t.delivery.DefaultTTL),
+		data:        emr.Data,
+		attributes:  copyAttributes(emr.Attributes),
+	})
+
+	if emr.DedupID != "" {
+		st.dedup[emr.DedupID] = dedupEntry{messageID: id, createdAt: now}
+	}
+	return id
+}
+
+// CheckMessage accepts any message, memory storage keeps data as is.
+func (t *simpleDelayQueue) CheckMessage(emr api.EnqueueMessageRequest) error {
+	return nil
+}
+
+func (t *simpleDelayQueue) AddBatch(emrs []api.EnqueueMessageRequest) ([]api.MessageID, error) {
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	out := make([]api.MessageID, len(emrs))
+	for i, emr := range emrs {
+		out[i] = formatMessageID(t.insert(st, emr, now))
+	}
+	return out, nil
+}
+
+func (t *simpleDelayQueue) Ack(ackKey string) error {
+	id, token, err := parseAckKey(ackKey)
+	if err != nil {
+		return err
+	}
+
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return err
+	}
+
+	m, ok := st.messages[id]
+	if !ok || m.ackToken != token {
+		return errors.New("ack ineffective")
+	}
+
+	delete(st.messages, id)
+	return nil
+}
+
+func (t *simpleDelayQueue) AckBatch(ackKeys []string) ([]string, error) {
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	ineffective := make([]string, 0)
+	for _, key := range ackKeys {
+		id, token, err := parseAckKey(key)
+		if err != nil {
+			ineffective = append(ineffective, key)
+			continue
+		}
+
+		m, ok := st.messages[id]
+		if !ok || m.ackToken != token {
+			ineffective = append(ineffective, key)
+			continue
+		}
+		delete(st.messages, id)
+	}
+	return ineffective, nil
+}
+
+func (t *simpleDelayQueue) Nack(ackKey string, delay time.Duration, reason string) error {
+	id, token, err := parseAckKey(ackKey)
+	if err != nil {
+		return err
+	}
+
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return err
+	}
+
+	m, ok := st.messages[id]
+	if !ok || m.ackToken == "" || m.ackToken != token {
+		return errors.New("nack ineffective")
+	}
+
+	if delay == 0 {
+		delay = t.delivery.RetryBackoff(m.attempts)
+	}
+
+	m.visibleAt = time.Now().Add(delay)
+	m.ackToken = ""
+	if reason != "" {
+		m.lastError = reason
+	}
+	return nil
+}
+
+func (t *simpleDelayQueue) ExtendVisibility(ackKey string, visibility time.Duration) error {
+	id, token, err := parseAckKey(ackKey)
+	if err != nil {
+		return err
+	}
+
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return err
+	}
+
+	m, ok := st.messages[id]
+	if !ok || m.ackToken == "" || m.ackToken != token {
+		return errors.New("extend ineffective")
+	}
+
+	m.visibleAt = time.Now().Add(visibility)
+	return nil
+}
+
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func (t *simpleDelayQueue) Stats() (api.QueueStats, error) {
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return api.QueueStats{}, err
+	}
+
+	now := time.Now()
+	stats := api.QueueStats{Expired: st.expired}
+	var oldest time.Time
+	for _, m := range st.messages {
+		if m.attempts > stats.MaxAttempts {
+			stats.MaxAttempts = m.attempts
+		}
+
+		switch {
+		case m.visibleAt.After(now) && m.ackToken != "":
+			stats.InFlight++
+		case m.visibleAt.After(now):
+			stats.Delayed++
+		case m.expiresAt.IsZero() || m.expiresAt.After(now):
+			stats.Visible++
+			if oldest.IsZero() || m.createdAt.Before(oldest) {
+				oldest = m.createdAt
+			}
+		}
+	}
+
+	if !oldest.IsZero() {
+		stats.OldestVisibleAge = now.Sub(oldest).Seconds()
+	}
+	return stats, nil
+}
+
+func (t *simpleDelayQueue) Peek(filter api.PeekFilter, cursor api.MessageID, limit int) ([]api.PeekedMessage, error) {
+	after, err := parseCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	st, err := t.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int64, 0)
+	for id := range st.messages {
+		if id > after {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	now := time.Now()
+	out := make([]api.PeekedMessage, 0, limit)
+	for _, id := range ids {
+		if len(out) >= limit {
+			break
+		}
+
+		m := st.messages[id]
+		if filter.State != "" && filter.State != m.state(now) {
+			continue
+		}
+
+		if !filter.Match(m.createdAt) {
+			continue
+		}
+
+		out = append(out, peeked(m, now))
+	}
+	return out, nil
+}
+
+func (t *simpleDelayQueue) Get(mid api.MessageID) (api.PeekedMessage, error) {
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	m, err := t.message(mid)
+	if err != nil {
+		return api.PeekedMessage{}, err
+	}
+	return peeked(m, time.Now()), nil
+}
+
+func (t *simpleDelayQueue) Cancel(mid api.MessageID) error {
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	m, err := t.message(mid)
+	if err != nil {
+		return err
+	}
+
+	if m.state(time.Now()) == api.InFlightMessageState {
+		return api.ErrMessageInFlight
+	}
+
+	st, err := t.storage()
+	if err != nil {
+		return err
+	}
+	delete(st.messages, m.id)
+	return nil
+}
+
+func (t *simpleDelayQueue) Reschedule(rr api.RescheduleRequest) error {
+	t.backend.mu.Lock()
+	defer t.backend.mu.Unlock()
+
+	m, err := t.message(rr.ID)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	if m.state(now) == api.InFlightMessageState {
+		return api.ErrMessageInFlight
+	}
+
+	// lease expired before reschedule, its ack key must not settle message.
+	m.ackToken = ""
+	m.scheduledAt = rr.ScheduleTime(now)
+	m.visibleAt = m.scheduledAt
+	return nil
+}
+
+// message must be called with backend lock held.
+func (t *simpleDelayQueue) message(mid api.MessageID) (*message, error) {
+	id, err := strconv.ParseInt(string(mid), 10, 64)
+	if err != nil {
+		return nil, api.ErrMessageNotFound
+	}
+
+	st, err := t.storage()
+	if err != nil {
+		return nil, err
+	}
+
+	m, ok := st.messages[id]
+	if !ok {
+		return nil, api.ErrMessageNotFound
+	}
+	return m, nil
+}
+
+func peeked(m *message, now time.Time) api.PeekedMessage {
+	return api.PeekedMessage{
+		Message: api.Message{
+			ID:          formatMessageID(m.id),
+			CreatedAt:   m.createdAt,
+			ScheduledAt: m.scheduledAt,
+			ExpiresAt:   nullTime(m.expiresAt),
+			Data:        m.data,
+			Attributes:  copyAttributes(m.attributes),
+			LastError:   m.lastError,
+		},
+		State:     m.state(now),
+		VisibleAt: m.visibleAt,
+		Attempts:  m.attempts,
+	}
+}
+
+func parseCursor(cursor api.MessageID) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(string(cursor), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid cursor")
+	}
+	return id, nil
+}
+
+func copyAttributes(attrs api.MessageAttributes) api.MessageAttributes {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	out := make(api.MessageAttributes, len(attrs))
+	for k, v := range attrs {
+		out[k] = v
+	}
+	return out
+}
+
+func newAckToken() string {
+	return fmt.Sprintf("%07x", rand.Int31n(1<<28))
+}
+
+func parseAckKey(s string) (int64, string, error) {
+	toks := strings.Split(s, "/")
+	if len(toks) != 2 {
+		return 0, "", errors.New("invalid ack key")
+	}
+
+	id, err := strconv.ParseInt(toks[0], 10, 64)
+	if err != nil {
+		return 0, "", errors.Wrap(err, "invalid ack key")
+	}
+
+	return id, toks[1], nil
+}
+
+func formatAckKey(id int64, token string) string {
+	return fmt.Sprintf("%d/%s", id, token)
+}
+
+func formatMessageID(id int64) api.MessageID {
+	return api.MessageID(strconv.FormatInt(id, 10))
+}
@@ -0,0 +1,325 @@
+package memory_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/palestamp/barnacle/pkg/api"
+	"github.com/palestamp/barnacle/pkg/backends/memory"
+)
+
+func newQueue(t *testing.T, qid api.QueueID) api.Queue {
+	backend, err := memory.NewConnector().Connect(api.ResourceID("test"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := backend.GetQueueManager(api.SimpleDelayQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manager.CreateQueue(api.RegisterQueueRequest{QueueID: qid}); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := manager.ConnectToQueue(api.QueueMetadata{QueueID: qid})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue
+}
+
+func poll(t *testing.T, queue api.Queue, limit int, visibility time.Duration) []api.Message {
+	msgs, err := queue.Poll(api.PollRequest{
+		Limit:      limit,
+		Deadline:   time.Now().Add(time.Second),
+		Visibility: visibility,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func TestDelayQueueAddPollAck(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	id, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	msgs := poll(t, queue, 10, time.Minute)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, id, msgs[0].ID)
+		assert.Equal(t, "smaug", msgs[0].Data)
+	}
+
+	// message is invisible until visibility timeout expires.
+	assert.Empty(t, poll(t, queue, 10, time.Minute))
+
+	assert.NoError(t, queue.Ack(msgs[0].AckKey))
+	assert.Error(t, queue.Ack(msgs[0].AckKey))
+}
+
+func TestDelayQueueDelay(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.Add(api.EnqueueMessageRequest{
+		QueueID: "erebor",
+		Delay:   api.Delay{Duration: 50 * time.Millisecond},
+		Data:    "smaug",
+	})
+	assert.NoError(t, err)
+
+	assert.Empty(t, poll(t, queue, 10, time.Minute))
+
+	time.Sleep(60 * time.Millisecond)
+	assert.Len(t, poll(t, queue, 10, time.Minute), 1)
+}
+
+func TestDelayQueueVisibility(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	first := poll(t, queue, 10, 20*time.Millisecond)
+	assert.Len(t, first, 1)
+
+	time.Sleep(30 * time.Millisecond)
+	second := poll(t, queue, 10, time.Minute)
+	if assert.Len(t, second, 1) {
+		// redelivery rotates ack key, so stale consumers can not ack.
+		assert.NotEqual(t, first[0].AckKey, second[0].AckKey)
+		assert.Error(t, queue.Ack(first[0].AckKey))
+		assert.NoError(t, queue.Ack(second[0].AckKey))
+	}
+}
+
+func TestDelayQueueNack(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	first := poll(t, queue, 10, time.Minute)
+	if !assert.Len(t, first, 1) {
+		return
+	}
+
+	assert.NoError(t, queue.Nack(first[0].AckKey, 0, "dragon is asleep"))
+	assert.Error(t, queue.Nack(first[0].AckKey, 0, ""))
+	assert.Error(t, queue.Ack(first[0].AckKey))
+
+	second := poll(t, queue, 10, time.Minute)
+	if assert.Len(t, second, 1) {
+		assert.Equal(t, "dragon is asleep", second[0].LastError)
+		assert.NoError(t, queue.Nack(second[0].AckKey, time.Minute, ""))
+	}
+
+	assert.Empty(t, poll(t, queue, 10, time.Minute))
+}
+
+func TestDelayQueueExtendVisibility(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	first := poll(t, queue, 10, 20*time.Millisecond)
+	if !assert.Len(t, first, 1) {
+		return
+	}
+
+	assert.NoError(t, queue.ExtendVisibility(first[0].AckKey, time.Minute))
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Empty(t, poll(t, queue, 10, time.Minute))
+
+	assert.NoError(t, queue.ExtendVisibility(first[0].AckKey, 0))
+	second := poll(t, queue, 10, time.Minute)
+	if assert.Len(t, second, 1) {
+		// ack key was rotated by the second poll.
+		assert.Error(t, queue.ExtendVisibility(first[0].AckKey, time.Minute))
+	}
+}
+
+func TestDelayQueueAckBatch(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.AddBatch([]api.EnqueueMessageRequest{{Data: "smaug"}, {Data: "balrog"}})
+	assert.NoError(t, err)
+
+	msgs := poll(t, queue, 10, time.Minute)
+	if !assert.Len(t, msgs, 2) {
+		return
+	}
+
+	ineffective, err := queue.AckBatch([]string{msgs[0].AckKey, "broken", msgs[1].AckKey})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"broken"}, ineffective)
+
+	ineffective, err = queue.AckBatch([]string{msgs[0].AckKey})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{msgs[0].AckKey}, ineffective)
+}
+
+func TestDelayQueueAckBatchDuplicateKeys(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	msgs := poll(t, queue, 10, time.Minute)
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+
+	// repeated key is ineffective as if keys were acknowledged one by one.
+	ineffective, err := queue.AckBatch([]string{msgs[0].AckKey, msgs[0].AckKey})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{msgs[0].AckKey}, ineffective)
+}
+
+func TestDelayQueueAttributes(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	attrs := api.MessageAttributes{"trace_id": "abc", "tenant": float64(42)}
+	_, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug", Attributes: attrs})
+	assert.NoError(t, err)
+
+	// queue keeps its own copy of attributes.
+	attrs["trace_id"] = "def"
+
+	msgs := poll(t, queue, 10, time.Minute)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, api.MessageAttributes{"trace_id": "abc", "tenant": float64(42)}, msgs[0].Attributes)
+	}
+}
+
+func TestDelayQueueDedup(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	first, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug", DedupID: "dragon"})
+	assert.NoError(t, err)
+
+	second, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug", DedupID: "dragon"})
+	assert.NoError(t, err)
+	assert.Equal(t, first, second)
+
+	ids, err := queue.AddBatch([]api.EnqueueMessageRequest{
+		{Data: "smaug", DedupID: "dragon"},
+		{Data: "balrog", DedupID: "demon"},
+		{Data: "balrog", DedupID: "demon"},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, ids, 3) {
+		assert.Equal(t, first, ids[0])
+		assert.Equal(t, ids[1], ids[2])
+	}
+
+	assert.Len(t, poll(t, queue, 10, time.Minute), 2)
+}
+
+func TestDelayQueueScheduledAt(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	scheduledAt := time.Now().Add(50 * time.Millisecond)
+	_, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug", ScheduledAt: scheduledAt})
+	assert.NoError(t, err)
+
+	assert.Empty(t, poll(t, queue, 10, time.Minute))
+
+	time.Sleep(60 * time.Millisecond)
+	msgs := poll(t, queue, 10, time.Minute)
+	if assert.Len(t, msgs, 1) {
+		assert.True(t, scheduledAt.Equal(msgs[0].ScheduledAt))
+	}
+}
+
+func TestDelayQueueExpiresAt(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.Add(api.EnqueueMessageRequest{
+		QueueID:   "erebor",
+		Data:      "smaug",
+		ExpiresAt: time.Now().Add(20 * time.Millisecond),
+	})
+	assert.NoError(t, err)
+
+	// in flight message is not expired until visibility timeout.
+	assert.Len(t, poll(t, queue, 10, 30*time.Millisecond), 1)
+	time.Sleep(40 * time.Millisecond)
+	assert.Empty(t, poll(t, queue, 10, time.Minute))
+}
+
+func TestDelayQueueStats(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.AddBatch([]api.EnqueueMessageRequest{
+		{Data: "smaug"},
+		{Data: "balrog"},
+		{Data: "witch-king", Delay: api.Delay{Duration: time.Minute}},
+		{Data: "saruman", TTL: api.Delay{Duration: 10 * time.Millisecond}},
+	})
+	assert.NoError(t, err)
+
+	assert.Len(t, poll(t, queue, 1, time.Minute), 1)
+	time.Sleep(20 * time.Millisecond)
+
+	stats, err := queue.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Visible)
+	assert.Equal(t, int64(1), stats.InFlight)
+	assert.Equal(t, int64(1), stats.Delayed)
+	assert.Equal(t, int64(0), stats.Expired)
+	assert.Equal(t, 1, stats.MaxAttempts)
+	assert.True(t, stats.OldestVisibleAge >= 0.02)
+
+	// expired messages are counted once poll removes them.
+	assert.Len(t, poll(t, queue, 10, time.Minute), 1)
+	stats, err = queue.Stats()
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), stats.Visible)
+	assert.Equal(t, int64(2), stats.InFlight)
+	assert.Equal(t, int64(1), stats.Expired)
+}
+
+func TestDelayQueueSubSecondDelay(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	_, err := queue.Add(api.EnqueueMessageRequest{
+		QueueID: "erebor",
+		Data:    "smaug",
+		Delay:   api.Delay{Duration: 500 * time.Millisecond},
+	})
+	assert.NoError(t, err)
+
+	assert.Empty(t, poll(t, queue, 10, time.Minute))
+
+	time.Sleep(500 * time.Millisecond)
+	assert.Len(t, poll(t, queue, 10, time.Minute), 1)
+}
+
+func TestDelayQueueRescheduleExpiredLease(t *testing.T) {
+	queue := newQueue(t, "erebor")
+
+	id, err := queue.Add(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	msgs := poll(t, queue, 10, 20*time.Millisecond)
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	assert.NoError(t, queue.Reschedule(api.RescheduleRequest{ID: id, Delay: api.Delay{Duration: time.Minute}}))
+
+	// rescheduled message is delayed, not leased to previous consumer.
+	assert.Error(t, queue.Ack(msgs[0].AckKey))
+	pm, err := queue.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, api.DelayedMessageState, pm.State)
+}
@@ -2,6 +2,8 @@ package postgres
 
 import (
 	"errors"
+	"fmt"
+	"sync"
 
 	"github.com/jackc/pgx"
 
@@ -18,15 +20,19 @@ var (
 	}
 )
 
-type managerInitializer func(*pgx.ConnPool) (api.Manager, error)
+type managerInitializer func(*PostgresBackend) (api.Manager, error)
 
 type PostgresBackend struct {
 	pool *pgx.ConnPool
+
+	mu       sync.Mutex
+	upgraded map[string]bool
 }
 
 func NewBackendFromPool(pool *pgx.ConnPool) *PostgresBackend {
 	return &PostgresBackend{
-		pool: pool,
+		pool:     pool,
+		upgraded: make(map[string]bool),
 	}
 }
 
@@ -35,5 +41,72 @@ func (s *PostgresBackend) GetQueueManager(qt api.QueueType) (api.Manager, error)
 	if !ok {
 		return nil, ErrUnknownQueueType
 	}
-	return queueManagerCreator(s.pool)
+	return queueManagerCreator(s)
+}
+
+// tableUpgrade is a schema change introduced after initial table layout,
+// both queries are formatted with table name.
+type tableUpgrade struct {
+	// applied reads catalog only, so neither lock nor owner privileges
+	// are needed when change is already in place.
+	applied string
+	stmt    string
+}
+
+// columnUpgrade adds column to queue table.
+func columnUpgrade(column, stmt string) tableUpgrade {
+	return tableUpgrade{
+		applied: `SELECT EXISTS (
+			SELECT 1 FROM information_schema.columns
+			WHERE table_schema = 'queues' AND table_name = '%[1]s' AND column_name = '` + column + `'
+		)`,
+		stmt: stmt,
+	}
+}
+
+// relationUpgrade creates table or index named relation in queues schema.
+func relationUpgrade(relation, stmt string) tableUpgrade {
+	return tableUpgrade{
+		applied: `SELECT to_regclass('queues.` + relation + `') IS NOT NULL`,
+		stmt:    stmt,
+	}
+}
+
+// upgradeTable brings table created by previous versions of Barnacle
+// to the current layout. Statements must be idempotent, they run only
+// when catalog shows change is missing, once per table per process.
+func (s *PostgresBackend) upgradeTable(table string, upgrades []tableUpgrade) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.upgraded[table] {
+		return nil
+	}
+
+	for _, u := range upgrades {
+		var applied bool
+		if err := s.pool.QueryRow(fmt.Sprintf(u.applied, table)).Scan(&applied); err != nil {
+			return err
+		}
+
+		if applied {
+			continue
+		}
+
+		if _, err := s.pool.Exec(fmt.Sprintf(u.stmt, table)); err != nil {
+			return err
+		}
+	}
+
+	s.upgraded[table] = true
+	return nil
+}
+
+// forgetTable makes table eligible for upgrade again, it is called
+// after table is dropped.
+func (s *PostgresBackend) forgetTable(table string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.upgraded, table)
 }
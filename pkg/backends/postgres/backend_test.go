@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackendUpgradeTable(t *testing.T) {
+	conn := testConn(t)
+	defer conn.Close()
+
+	testExec(t, conn,
+		`CREATE SCHEMA IF NOT EXISTS queues`,
+		`DROP TABLE IF EXISTS queues.ut_upgrade, queues.ut_upgrade_dedup, queues.ut_upgrade_counters`,
+		// layout of the first version
+		`CREATE TABLE queues.ut_upgrade (
+			message_id BIGSERIAL PRIMARY KEY,
+			created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			visible_at TIMESTAMP WITH TIME ZONE NOT NULL,
+			ack_token varchar(32),
+			attempts int NOT NULL DEFAULT 0,
+			data text
+		)`,
+	)
+	defer testExec(t, conn,
+		`DROP TABLE IF EXISTS queues.ut_upgrade, queues.ut_upgrade_dedup, queues.ut_upgrade_counters`)
+
+	connConfig, err := pgx.ParseURI(testDbURI)
+	assert.NoError(t, err)
+	pool, err := pgx.NewConnPool(pgx.ConnPoolConfig{ConnConfig: connConfig})
+	assert.NoError(t, err)
+	defer pool.Close()
+
+	assert.NoError(t, NewBackendFromPool(pool).upgradeTable("ut_upgrade", delayQueueUpgrades))
+
+	for _, u := range delayQueueUpgrades {
+		var applied bool
+		assert.NoError(t, conn.QueryRow(fmt.Sprintf(u.applied, "ut_upgrade")).Scan(&applied))
+		assert.True(t, applied, u.stmt)
+	}
+
+	// upgraded table is only inspected by a fresh backend.
+	assert.NoError(t, NewBackendFromPool(pool).upgradeTable("ut_upgrade", delayQueueUpgrades))
+}
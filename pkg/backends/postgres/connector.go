@@ -1,12 +1,20 @@
 package postgres
 
 import (
+	"sync"
+
 	"github.com/jackc/pgx"
+	"github.com/pkg/errors"
 
 	"github.com/palestamp/barnacle/pkg/api"
 	"github.com/palestamp/barnacle/pkg/machinery/decode"
 )
 
+var (
+	ErrQueuesSchemaNotFound    = errors.New("schema queues does not exist")
+	ErrQueuesSchemaNotWritable = errors.New("schema queues is not writable")
+)
+
 type ResourceConnOptions struct {
 	URI string `mapstructure:"uri"`
 }
@@ -22,11 +30,12 @@ func NewConnector() api.Connector {
 }
 
 type connectorCacheEntry struct {
-	backend   api.Backend
+	backend   *PostgresBackend
 	verifyKey string
 }
 
 type connector struct {
+	mu    sync.Mutex
 	cache map[api.ResourceID]connectorCacheEntry
 }
 
@@ -36,6 +45,9 @@ func (c *connector) Connect(rid api.ResourceID, ops api.ResourceConnOptions) (ap
 		return nil, err
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	if entry := c.lookupCache(rid, op.verifyKey()); entry != nil {
 		return entry.backend, nil
 	}
@@ -57,6 +69,44 @@ func (c *connector) Connect(rid api.ResourceID, ops api.ResourceConnOptions) (ap
 	return backend, nil
 }
 
+func (c *connector) Verify(ops api.ResourceConnOptions) error {
+	var op ResourceConnOptions
+	if err := decode.Decode(ops, &op); err != nil {
+		return err
+	}
+
+	connConfig, err := pgx.ParseURI(op.URI)
+	if err != nil {
+		return err
+	}
+
+	conn, err := pgx.Connect(connConfig)
+	if err != nil {
+		return errors.Wrap(err, "resource connection failed")
+	}
+	defer conn.Close()
+
+	var exists, writable bool
+	err = conn.QueryRow(`
+	SELECT
+		EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = 'queues'),
+		EXISTS (SELECT 1 FROM pg_namespace WHERE nspname = 'queues'
+			AND has_schema_privilege(oid, 'USAGE')
+			AND has_schema_privilege(oid, 'CREATE'))`).Scan(&exists, &writable)
+	if err != nil {
+		return errors.Wrap(err, "resource privilege check failed")
+	}
+
+	if !exists {
+		return ErrQueuesSchemaNotFound
+	}
+
+	if !writable {
+		return ErrQueuesSchemaNotWritable
+	}
+	return nil
+}
+
 func (c *connector) lookupCache(rid api.ResourceID, verifyKey string) *connectorCacheEntry {
 	entry, ok := c.cache[rid]
 	if !ok {
@@ -64,14 +114,30 @@ func (c *connector) lookupCache(rid api.ResourceID, verifyKey string) *connector
 	}
 
 	if entry.verifyKey != verifyKey {
-		delete(c.cache, rid)
+		c.invalidate(rid)
 		return nil
 	}
 
 	return &entry
 }
 
-func (c *connector) setCache(rid api.ResourceID, verifyKey string, backend api.Backend) {
+func (c *connector) Invalidate(rid api.ResourceID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.invalidate(rid)
+}
+
+// invalidate must be called with connector lock held. Pool connections
+// which are in use are closed once released.
+func (c *connector) invalidate(rid api.ResourceID) {
+	if entry, ok := c.cache[rid]; ok {
+		entry.backend.pool.Close()
+		delete(c.cache, rid)
+	}
+}
+
+func (c *connector) setCache(rid api.ResourceID, verifyKey string, backend *PostgresBackend) {
 	c.cache[rid] = connectorCacheEntry{
 		backend:   backend,
 		verifyKey: verifyKey,
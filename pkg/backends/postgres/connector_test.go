@@ -0,0 +1,63 @@
+package postgres
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/palestamp/barnacle/pkg/api"
+)
+
+const testDbURI = "postgresql://postgres@localhost:5434/barnacle"
+
+// testConn connects to test database, test is skipped when it is not available.
+func testConn(t *testing.T) *pgx.Conn {
+	connConfig, err := pgx.ParseURI(testDbURI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := pgx.Connect(connConfig)
+	if err != nil {
+		t.Skipf("test database is not available: %v", err)
+	}
+	return conn
+}
+
+func testExec(t *testing.T, conn *pgx.Conn, stmts ...string) {
+	for _, stmt := range stmts {
+		if _, err := conn.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestConnectorVerifySchemaPrivileges(t *testing.T) {
+	conn := testConn(t)
+	defer conn.Close()
+
+	// throwaway role, dropped together with its privileges.
+	role := fmt.Sprintf("barnacle_usage_%d", time.Now().UnixNano())
+
+	testExec(t, conn,
+		`CREATE SCHEMA IF NOT EXISTS queues`,
+		`CREATE ROLE `+role+` LOGIN`,
+	)
+	defer testExec(t, conn,
+		`REVOKE ALL ON SCHEMA queues FROM `+role,
+		`DROP ROLE `+role,
+	)
+	testExec(t, conn, `GRANT USAGE ON SCHEMA queues TO `+role)
+
+	c := NewConnector()
+	assert.NoError(t, c.Verify(api.ResourceConnOptions{"uri": testDbURI}))
+
+	// usage alone does not allow creation of queue tables.
+	err := c.Verify(api.ResourceConnOptions{
+		"uri": "postgresql://" + role + "@localhost:5434/barnacle",
+	})
+	assert.Equal(t, ErrQueuesSchemaNotWritable, err)
+}
@@ -2,10 +2,14 @@ package postgres
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/jackc/pgx"
 	"github.com/pkg/errors"
@@ -14,31 +18,92 @@ import (
 	"github.com/palestamp/barnacle/pkg/machinery/decode"
 )
 
-var ErrTableNameInvalid = errors.New("table name invalid")
+var (
+	ErrTableNameInvalid          = errors.New("table name invalid")
+	ErrTableNameReserved         = errors.New("table name suffixes _dedup and _counters are reserved")
+	ErrDeadLetterQueueUnresolved = errors.New("dead letter queue metadata not resolved")
+	ErrExpiryQueueUnresolved     = errors.New("expiry queue metadata not resolved")
+	ErrStorageUnknown            = errors.New("storage must be either text or bytea")
+	ErrStorageMismatch           = errors.New("referenced queue must use the same storage")
+	ErrBinaryData                = errors.New("data is not valid UTF-8 text, use queue with bytea storage")
+)
+
+const (
+	// textStorage keeps message data in text column, data must be UTF-8
+	// without NUL bytes.
+	textStorage = "text"
+	// byteaStorage keeps message data in bytea column.
+	byteaStorage = "bytea"
+)
 
-func NewDelayQueueManager(pool *pgx.ConnPool) (api.Manager, error) {
-	return &delayQueueManager{pool: pool}, nil
+func NewDelayQueueManager(backend *PostgresBackend) (api.Manager, error) {
+	return &delayQueueManager{backend: backend, pool: backend.pool}, nil
 }
 
 type delayQueueManager struct {
-	pool *pgx.ConnPool
+	backend *PostgresBackend
+	pool    *pgx.ConnPool
 }
 
-var queueTableNamePattern = regexp.MustCompile(`[a-z][a-z0-9_]{0,31s}`)
+// delayQueueUpgrades add columns and tables introduced after initial
+// table layout.
+var delayQueueUpgrades = []tableUpgrade{
+	columnUpgrade("last_error",
+		`ALTER TABLE queues.%[1]s ADD COLUMN IF NOT EXISTS last_error text`),
+	columnUpgrade("attributes",
+		`ALTER TABLE queues.%[1]s ADD COLUMN IF NOT EXISTS attributes jsonb`),
+	relationUpgrade("%[1]s_dedup", `CREATE TABLE IF NOT EXISTS queues.%[1]s_dedup (
+		dedup_id varchar(128) PRIMARY KEY,
+		message_id bigint,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`),
+	relationUpgrade("idx_%[1]s_dedup_created_at",
+		`CREATE INDEX IF NOT EXISTS idx_%[1]s_dedup_created_at ON queues.%[1]s_dedup (created_at)`),
+	columnUpgrade("expires_at",
+		`ALTER TABLE queues.%[1]s ADD COLUMN IF NOT EXISTS expires_at timestamptz`),
+	relationUpgrade("idx_%[1]s_expires_at",
+		`CREATE INDEX IF NOT EXISTS idx_%[1]s_expires_at ON queues.%[1]s (expires_at) WHERE expires_at IS NOT NULL`),
+	relationUpgrade("%[1]s_counters", `CREATE TABLE IF NOT EXISTS queues.%[1]s_counters (
+		name varchar(32) PRIMARY KEY,
+		value bigint NOT NULL DEFAULT 0
+	)`),
+}
+
+// expiredCounter is a name of counter of expired messages in _counters table.
+const expiredCounter = "expired"
+
+var queueTableNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,31}$`)
+
+// companionSuffixes name tables kept next to queue table, queue tables
+// can not use them, so companion table never belongs to another queue.
+var companionSuffixes = []string{"_dedup", "_counters"}
 
 type delayQueueOptions struct {
-	Table string `mapstructure:"table"`
+	api.DeliveryOptions `mapstructure:",squash"`
+
+	Table   string `mapstructure:"table"`
+	Storage string `mapstructure:"storage"`
 }
 
 func (dq *delayQueueOptions) Validate() error {
 	if !queueTableNamePattern.MatchString(dq.Table) {
 		return ErrTableNameInvalid
 	}
-	return nil
+
+	for _, suffix := range companionSuffixes {
+		if strings.HasSuffix(dq.Table, suffix) {
+			return ErrTableNameReserved
+		}
+	}
+
+	if dq.Storage != textStorage && dq.Storage != byteaStorage {
+		return ErrStorageUnknown
+	}
+	return dq.DeliveryOptions.Validate()
 }
 
 func (s *delayQueueManager) decodeOpts(qm api.QueueOptions) (delayQueueOptions, error) {
-	var ops delayQueueOptions
+	ops := delayQueueOptions{Storage: textStorage}
 	if err := decode.Decode(qm, &ops); err != nil {
 		return ops, err
 	}
@@ -54,31 +119,143 @@ func (s *delayQueueManager) CreateQueue(rqr api.RegisterQueueRequest) error {
 	}
 
 	stmt := fmt.Sprintf(`
-	CREATE TABLE queues.%s (
+	CREATE TABLE queues.%[1]s (
 		message_id BIGSERIAL PRIMARY KEY,
 		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
 		visible_at TIMESTAMP WITH TIME ZONE NOT NULL,
 		ack_token varchar(32),
 		attempts int NOT NULL DEFAULT 0,
-		data text
+		data %[2]s,
+		last_error text,
+		attributes jsonb,
+		expires_at TIMESTAMP WITH TIME ZONE
+	);
+	CREATE INDEX idx_%[1]s_visible_at ON queues.%[1]s (visible_at);
+	CREATE INDEX idx_%[1]s_expires_at ON queues.%[1]s (expires_at) WHERE expires_at IS NOT NULL;
+	CREATE TABLE queues.%[1]s_dedup (
+		dedup_id varchar(128) PRIMARY KEY,
+		message_id bigint,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
 	);
-	CREATE INDEX idx_%s_visible_at ON queues.%s (visible_at);
-	`, ops.Table, ops.Table, ops.Table)
+	CREATE INDEX idx_%[1]s_dedup_created_at ON queues.%[1]s_dedup (created_at);
+	CREATE TABLE queues.%[1]s_counters (
+		name varchar(32) PRIMARY KEY,
+		value bigint NOT NULL DEFAULT 0
+	);
+	`, ops.Table, ops.Storage)
 
 	_, err = s.pool.Exec(stmt)
 	return err
 }
 
-func (s *delayQueueManager) Delete(qm api.QueueMetadata) error {
+func (s *delayQueueManager) DeleteQueue(qm api.QueueMetadata, force bool) error {
 	ops, err := s.decodeOpts(qm.Options)
 	if err != nil {
 		return err
 	}
 
-	stmt := fmt.Sprintf("DROP TABLE %s", ops.Table)
-	_, err = s.pool.Exec(stmt)
-	return err
+	tx, err := s.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !force {
+		var exists bool
+		err := tx.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1 FROM pg_tables WHERE schemaname = 'queues' AND tablename = $1
+		)`, ops.Table).Scan(&exists)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			// lock prevents inserts between check and drop
+			stmt := fmt.Sprintf(`LOCK TABLE queues.%s IN EXCLUSIVE MODE`, ops.Table)
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+
+			var notEmpty bool
+			stmt = fmt.Sprintf(`SELECT EXISTS (SELECT 1 FROM queues.%s)`, ops.Table)
+			if err := tx.QueryRow(stmt).Scan(&notEmpty); err != nil {
+				return err
+			}
+
+			if notEmpty {
+				return api.ErrQueueNotEmpty
+			}
+		}
+	}
+
+	tables, err := companionTables(tx, ops.Table)
+	if err != nil {
+		return err
+	}
+
+	tables = append(tables, "queues."+ops.Table)
+	stmt := fmt.Sprintf(`DROP TABLE IF EXISTS %s`, strings.Join(tables, ", "))
+	if _, err := tx.Exec(stmt); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.backend.forgetTable(ops.Table)
+	return nil
+}
+
+// companionTables returns qualified names of existing companion tables
+// of queue table. Tables are recognized by their own columns, so table
+// which was not created for queue is never returned.
+func companionTables(tx *pgx.Tx, table string) ([]string, error) {
+	rows, err := tx.Query(`
+	SELECT table_name
+	FROM information_schema.columns
+	WHERE table_schema = 'queues' AND (
+		(table_name = $1 || '_dedup' AND column_name = 'dedup_id')
+		OR (table_name = $1 || '_counters' AND column_name = 'value')
+	)`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]string, 0, len(companionSuffixes)+1)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		out = append(out, "queues."+name)
+	}
+	return out, rows.Err()
+}
+
+func (s *delayQueueManager) PurgeQueue(qm api.QueueMetadata, pr api.PurgeRequest) (int64, error) {
+	ops, err := s.decodeOpts(qm.Options)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := fmt.Sprintf(`
+	DELETE FROM queues.%s
+	WHERE ($2::timestamptz IS NULL OR scheduled_at < $2)
+		AND CASE $1
+			WHEN 'delayed' THEN visible_at > NOW() AND ack_token IS NULL
+			WHEN 'visible' THEN visible_at <= NOW()
+			ELSE true
+		END`, ops.Table)
+
+	ct, err := s.pool.Exec(stmt, string(pr.Only), nullTime(pr.ScheduledBefore))
+	if err != nil {
+		return 0, err
+	}
+	return ct.RowsAffected(), nil
 }
 
 func (s *delayQueueManager) ConnectToQueue(qm api.QueueMetadata) (api.Queue, error) {
@@ -87,27 +264,105 @@ func (s *delayQueueManager) ConnectToQueue(qm api.QueueMetadata) (api.Queue, err
 		return nil, err
 	}
 
-	return newSimpleDelayQueue(s.pool, ops.Table)
+	if err := s.backend.upgradeTable(ops.Table, delayQueueUpgrades); err != nil {
+		return nil, err
+	}
+
+	queue, err := newSimpleDelayQueue(s.pool, ops.Table)
+	if err != nil {
+		return nil, err
+	}
+	queue.storage = ops.Storage
+	queue.delivery = ops.DeliveryOptions
+
+	if ops.DeadLetterQueue != "" {
+		dlq, ok := qm.References[ops.DeadLetterQueue]
+		if !ok {
+			return nil, ErrDeadLetterQueueUnresolved
+		}
+
+		if queue.deadLetterTable, err = s.referencedTable(ops, dlq); err != nil {
+			return nil, err
+		}
+	}
+
+	if ops.ExpiryQueue != "" {
+		eq, ok := qm.References[ops.ExpiryQueue]
+		if !ok {
+			return nil, ErrExpiryQueueUnresolved
+		}
+
+		if queue.expiryTable, err = s.referencedTable(ops, eq); err != nil {
+			return nil, err
+		}
+	}
+
+	return queue, nil
+}
+
+// referencedTable returns table of queue which messages are moved into.
+func (s *delayQueueManager) referencedTable(ops delayQueueOptions, ref api.QueueMetadata) (string, error) {
+	refOps, err := s.decodeOpts(ref.Options)
+	if err != nil {
+		return "", err
+	}
+
+	if refOps.Storage != ops.Storage {
+		return "", ErrStorageMismatch
+	}
+
+	if err := s.backend.upgradeTable(refOps.Table, delayQueueUpgrades); err != nil {
+		return "", err
+	}
+	return refOps.Table, nil
 }
 
 type simpleDelayQueue struct {
-	pool  *pgx.ConnPool
-	table string
+	pool    *pgx.ConnPool
+	table   string
+	storage string
+
+	delivery        api.DeliveryOptions
+	deadLetterTable string
+	expiryTable     string
 }
 
 func newSimpleDelayQueue(pool *pgx.ConnPool, tableName string) (*simpleDelayQueue, error) {
 	tp := &simpleDelayQueue{
-		pool:  pool,
-		table: tableName,
+		pool:    pool,
+		table:   tableName,
+		storage: textStorage,
 	}
 	return tp, nil
 }
 
 func (t *simpleDelayQueue) Poll(pr api.PollRequest) ([]api.Message, error) {
+	ctx, cancel := context.WithDeadline(context.Background(), pr.Deadline)
+	defer cancel()
+
+	if err := t.expire(ctx, pr.Limit); err != nil {
+		return nil, err
+	}
+
+	if t.delivery.MaxAttempts > 0 {
+		if err := t.moveDeadLetters(ctx, pr.Limit); err != nil {
+			return nil, err
+		}
+	}
+
+	args := []interface{}{
+		pr.Limit,
+		t.delivery.MaxAttempts,
+		nullTime(pr.Filter.CreatedAfter),
+		nullTime(pr.Filter.CreatedBefore),
+		pr.Visibility.Seconds(),
+	}
+	backoff, args := t.retryBackoffExpr("attempts + 1", args)
+
 	stmt := fmt.Sprintf(`
 	UPDATE queues.%s as original
 	SET 
-		visible_at = NOW() + interval '%d seconds',
+		visible_at = NOW() + $5 * interval '1 second' + %s,
 		attempts = attempts + 1,
 		ack_token = substring(md5(random()::text) from 1 for 7)
 	FROM (
@@ -115,7 +370,10 @@ func (t *simpleDelayQueue) Poll(pr api.PollRequest) ([]api.Message, error) {
 			message_id
 		FROM
 			queues.%s
-		WHERE visible_at <= NOW()
+		WHERE visible_at <= NOW() AND ($2 = 0 OR attempts < $2)
+			AND ($3::timestamptz IS NULL OR created_at >= $3)
+			AND ($4::timestamptz IS NULL OR created_at < $4)
+			AND (expires_at IS NULL OR expires_at > NOW())
 		LIMIT $1
 		FOR UPDATE SKIP LOCKED
 	) as subquery
@@ -125,51 +383,597 @@ func (t *simpleDelayQueue) Poll(pr api.PollRequest) ([]api.Message, error) {
 		original.created_at,
 		original.scheduled_at,
 		original.data,
-		original.ack_token
-	`, t.table, int64(pr.Visibility.Seconds()), t.table)
-
-	ctx, cancel := context.WithDeadline(context.Background(), pr.Deadline)
-	defer cancel()
+		original.ack_token,
+		original.last_error,
+		original.attributes,
+		original.expires_at
+	`, t.table, backoff, t.table)
 
-	rows, err := t.pool.QueryEx(ctx, stmt, nil, pr.Limit)
+	rows, err := t.pool.QueryEx(ctx, stmt, nil, args...)
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
 
 	out := make([]api.Message, 0, pr.Limit)
 	for rows.Next() {
 		var messageID int64
 		var ackToken string
+		var lastError *string
+		var data, attributes []byte
 		var message api.Message
 		if err := rows.Scan(
 			&messageID,
 			&message.CreatedAt,
 			&message.ScheduledAt,
-			&message.Data,
+			&data,
 			&ackToken,
+			&lastError,
+			&attributes,
+			&message.ExpiresAt,
 		); err != nil {
 			return nil, err
 		}
 
+		message.Data = string(data)
+		if lastError != nil {
+			message.LastError = *lastError
+		}
+
+		if message.Attributes, err = unmarshalAttributes(attributes); err != nil {
+			return nil, err
+		}
+
 		message.ID = formatMessageID(messageID)
 		message.AckKey = formatAckKey(messageID, ackToken)
 		out = append(out, message)
 	}
 
-	return out, nil
+	return out, rows.Err()
+}
+
+// expire removes up to limit expired messages which are not in flight,
+// they are moved into expiry table if queue has one. Counter of expired
+// messages is updated by the same statement.
+func (t *simpleDelayQueue) expire(ctx context.Context, limit int) error {
+	move := ""
+	if t.expiryTable != "" {
+		move = fmt.Sprintf(`
+	, moved AS (
+		INSERT INTO queues.%s (created_at, scheduled_at, visible_at, data, last_error, attributes)
+		SELECT created_at, scheduled_at, NOW(), data, last_error, attributes FROM expired
+	)`, t.expiryTable)
+	}
+
+	stmt := fmt.Sprintf(`
+	WITH expired AS (
+		DELETE FROM queues.%[1]s
+		WHERE message_id IN (
+			SELECT
+				message_id
+			FROM
+				queues.%[1]s
+			WHERE expires_at <= NOW() AND (ack_token IS NULL OR visible_at <= NOW())
+			LIMIT $1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING created_at, scheduled_at, data, last_error, attributes
+	)%[2]s
+	INSERT INTO queues.%[1]s_counters (name, value)
+	SELECT $2, count(*) FROM expired HAVING count(*) > 0
+	ON CONFLICT (name) DO UPDATE SET value = %[1]s_counters.value + EXCLUDED.value
+	`, t.table, move)
+
+	_, err := t.pool.ExecEx(ctx, stmt, nil, limit, expiredCounter)
+	return err
+}
+
+// moveDeadLetters moves up to limit visible messages which reached
+// max attempts into dead letter queue table. Move is done with a single
+// statement, so message is either in source or in dead letter queue.
+func (t *simpleDelayQueue) moveDeadLetters(ctx context.Context, limit int) error {
+	stmt := fmt.Sprintf(`
+	WITH dead AS (
+		DELETE FROM queues.%s
+		WHERE message_id IN (
+			SELECT
+				message_id
+			FROM
+				queues.%s
+			WHERE visible_at <= NOW() AND attempts >= $1
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING created_at, scheduled_at, data, last_error, attributes
+	)
+	INSERT INTO queues.%s (created_at, scheduled_at, visible_at, data, last_error, attributes)
+	SELECT created_at, scheduled_at, NOW(), data, last_error, attributes FROM dead
+	`, t.table, t.table, t.deadLetterTable)
+
+	_, err := t.pool.ExecEx(ctx, stmt, nil, t.delivery.MaxAttempts, limit)
+	return err
+}
+
+// retryBackoffExpr returns SQL interval expression of redelivery backoff
+// for message which failed n times, where n is SQL expression.
+// Expression parameters are appended to args.
+func (t *simpleDelayQueue) retryBackoffExpr(n string, args []interface{}) (string, []interface{}) {
+	delayParam := len(args) + 1
+	exponential := fmt.Sprintf("LEAST($%d, $%d * power(2, LEAST(%s, 32) - 1))", delayParam+1, delayParam, n)
+
+	switch t.delivery.RetryPolicy {
+	case api.FixedRetryPolicy:
+		return fmt.Sprintf("$%d * interval '1 second'", delayParam),
+			append(args, t.delivery.RetryDelay.Seconds())
+	case api.ExponentialRetryPolicy:
+		return exponential + " * interval '1 second'",
+			append(args, t.delivery.RetryDelay.Seconds(), nullSeconds(t.delivery.RetryMaxDelay))
+	case api.ExponentialJitterRetryPolicy:
+		return "random() * " + exponential + " * interval '1 second'",
+			append(args, t.delivery.RetryDelay.Seconds(), nullSeconds(t.delivery.RetryMaxDelay))
+	}
+	return "interval '0 seconds'", args
+}
+
+// dataArg converts message data into query argument for data column.
+func (t *simpleDelayQueue) dataArg(data string) (interface{}, error) {
+	if t.storage == byteaStorage {
+		return []byte(data), nil
+	}
+
+	if !utf8.ValidString(data) || strings.IndexByte(data, 0) >= 0 {
+		return nil, ErrBinaryData
+	}
+	return data, nil
+}
+
+// Stats computes queue statistics with a single scan of queue table.
+func (t *simpleDelayQueue) Stats() (api.QueueStats, error) {
+	stmt := fmt.Sprintf(`
+	SELECT
+		count(*) FILTER (WHERE visible_at <= NOW() AND (expires_at IS NULL OR expires_at > NOW())),
+		count(*) FILTER (WHERE visible_at > NOW() AND ack_token IS NOT NULL),
+		count(*) FILTER (WHERE visible_at > NOW() AND ack_token IS NULL),
+		COALESCE(EXTRACT(EPOCH FROM NOW() - min(created_at) FILTER (
+			WHERE visible_at <= NOW() AND (expires_at IS NULL OR expires_at > NOW())
+		)), 0)::float8,
+		COALESCE(max(attempts), 0),
+		COALESCE((SELECT value FROM queues.%[1]s_counters WHERE name = $1), 0)
+	FROM queues.%[1]s`, t.table)
+
+	var stats api.QueueStats
+	var maxAttempts int32
+	err := t.pool.QueryRow(stmt, expiredCounter).Scan(
+		&stats.Visible,
+		&stats.InFlight,
+		&stats.Delayed,
+		&stats.OldestVisibleAge,
+		&maxAttempts,
+		&stats.Expired,
+	)
+	stats.MaxAttempts = int(maxAttempts)
+	return stats, err
+}
+
+// peekStmt selects messages with their state without locking them,
+// it is formatted with table name and condition.
+const peekStmt = `
+	SELECT
+		message_id,
+		created_at,
+		scheduled_at,
+		visible_at,
+		expires_at,
+		attempts,
+		data,
+		last_error,
+		attributes,
+		state
+	FROM (
+		SELECT
+			*,
+			CASE
+				WHEN visible_at > NOW() AND ack_token IS NOT NULL THEN 'in_flight'
+				WHEN expires_at <= NOW() THEN 'expired'
+				WHEN visible_at > NOW() THEN 'delayed'
+				ELSE 'visible'
+			END AS state
+		FROM queues.%s
+	) AS m
+	WHERE %s`
+
+// scanner is implemented by both *pgx.Row and *pgx.Rows.
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanPeeked(row scanner) (api.PeekedMessage, error) {
+	var messageID int64
+	var attempts int32
+	var lastError *string
+	var state string
+	var data, attributes []byte
+	var message api.PeekedMessage
+	if err := row.Scan(
+		&messageID,
+		&message.CreatedAt,
+		&message.ScheduledAt,
+		&message.VisibleAt,
+		&message.ExpiresAt,
+		&attempts,
+		&data,
+		&lastError,
+		&attributes,
+		&state,
+	); err != nil {
+		return message, err
+	}
+
+	message.Data = string(data)
+	if lastError != nil {
+		message.LastError = *lastError
+	}
+
+	var err error
+	if message.Attributes, err = unmarshalAttributes(attributes); err != nil {
+		return message, err
+	}
+
+	message.ID = formatMessageID(messageID)
+	message.Attempts = int(attempts)
+	message.State = api.MessageState(state)
+	return message, nil
+}
+
+// Peek reads messages without locking them, so concurrent polls are
+// not affected and returned states may be slightly stale.
+func (t *simpleDelayQueue) Peek(filter api.PeekFilter, cursor api.MessageID, limit int) ([]api.PeekedMessage, error) {
+	after, err := parseCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	stmt := fmt.Sprintf(peekStmt, t.table, `message_id > $1
+		AND ($3::timestamptz IS NULL OR created_at >= $3)
+		AND ($4::timestamptz IS NULL OR created_at < $4)
+		AND ($5 = '' OR state = $5)
+	ORDER BY message_id
+	LIMIT $2`)
+
+	rows, err := t.pool.Query(stmt,
+		after,
+		limit,
+		nullTime(filter.CreatedAfter),
+		nullTime(filter.CreatedBefore),
+		string(filter.State),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make([]api.PeekedMessage, 0, limit)
+	for rows.Next() {
+		message, err := scanPeeked(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, message)
+	}
+
+	return out, rows.Err()
+}
+
+func (t *simpleDelayQueue) Get(mid api.MessageID) (api.PeekedMessage, error) {
+	id, err := strconv.ParseInt(string(mid), 10, 64)
+	if err != nil {
+		return api.PeekedMessage{}, api.ErrMessageNotFound
+	}
+
+	stmt := fmt.Sprintf(peekStmt, t.table, `message_id = $1`)
+	message, err := scanPeeked(t.pool.QueryRow(stmt, id))
+	if err == pgx.ErrNoRows {
+		return message, api.ErrMessageNotFound
+	}
+	return message, err
+}
+
+func (t *simpleDelayQueue) Cancel(mid api.MessageID) error {
+	tx, err := t.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := t.lockIdle(tx, mid)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf(`DELETE FROM queues.%s WHERE message_id = $1`, t.table)
+	if _, err := tx.Exec(stmt, id); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (t *simpleDelayQueue) Reschedule(rr api.RescheduleRequest) error {
+	tx, err := t.pool.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	id, err := t.lockIdle(tx, rr.ID)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf(`
+	UPDATE queues.%s
+	SET
+		scheduled_at = COALESCE($2::timestamptz, NOW() + $3 * interval '1 second'),
+		visible_at = COALESCE($2::timestamptz, NOW() + $3 * interval '1 second'),
+		ack_token = NULL
+	WHERE message_id = $1`, t.table)
+	if _, err := tx.Exec(stmt, id, nullTime(rr.ScheduledAt), rr.Delay.Seconds()); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lockIdle locks message which is not in flight till the end of transaction.
+func (t *simpleDelayQueue) lockIdle(tx *pgx.Tx, mid api.MessageID) (int64, error) {
+	id, err := strconv.ParseInt(string(mid), 10, 64)
+	if err != nil {
+		return 0, api.ErrMessageNotFound
+	}
+
+	var inFlight bool
+	stmt := fmt.Sprintf(`
+	SELECT visible_at > NOW() AND ack_token IS NOT NULL
+	FROM queues.%s
+	WHERE message_id = $1
+	FOR UPDATE`, t.table)
+	err = tx.QueryRow(stmt, id).Scan(&inFlight)
+	if err == pgx.ErrNoRows {
+		return 0, api.ErrMessageNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if inFlight {
+		return 0, api.ErrMessageInFlight
+	}
+	return id, nil
+}
+
+// queryer is implemented by both *pgx.ConnPool and *pgx.Tx.
+type queryer interface {
+	QueryRow(sql string, args ...interface{}) *pgx.Row
+	Exec(sql string, arguments ...interface{}) (pgx.CommandTag, error)
 }
 
 func (t *simpleDelayQueue) Add(emr api.EnqueueMessageRequest) (api.MessageID, error) {
-	delay := int64(emr.Delay.Seconds())
+	if emr.DedupID == "" {
+		id, err := t.insert(t.pool, emr)
+		return formatMessageID(id), err
+	}
+
+	tx, err := t.pool.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	id, err := t.insertDedup(tx, emr)
+	if err != nil {
+		return "", err
+	}
+	return formatMessageID(id), tx.Commit()
+}
+
+func (t *simpleDelayQueue) insert(q queryer, emr api.EnqueueMessageRequest) (int64, error) {
+	data, err := t.dataArg(emr.Data)
+	if err != nil {
+		return 0, err
+	}
+
+	attributes, err := marshalAttributes(emr.Attributes)
+	if err != nil {
+		return 0, err
+	}
+
 	stmt := fmt.Sprintf(`
-		INSERT INTO 
-		queues.%s(data, scheduled_at, visible_at) VALUES
-			($1, NOW() + interval '%d seconds',  NOW() + interval '%d seconds') RETURNING message_id`,
-		t.table, delay, delay)
+	WITH s AS (
+		SELECT
+			COALESCE($3::timestamptz, NOW() + $4 * interval '1 second') AS scheduled_at,
+			COALESCE(NOW() + $6 * interval '1 second', $5::timestamptz) AS expires_at
+	)
+	INSERT INTO queues.%s (data, attributes, scheduled_at, visible_at, expires_at)
+	SELECT $1, NULLIF($2, '')::jsonb, s.scheduled_at, s.scheduled_at, s.expires_at FROM s
+	RETURNING message_id`, t.table)
 
 	var messageID int64
-	err := t.pool.QueryRow(stmt, emr.Data).Scan(&messageID)
-	return formatMessageID(messageID), err
+	err = q.QueryRow(stmt,
+		data,
+		attributes,
+		nullTime(emr.ScheduledAt),
+		emr.Delay.Seconds(),
+		nullTime(emr.ExpiresAt),
+		nullSeconds(emr.ExpiryTTL(t.delivery.DefaultTTL)),
+	).Scan(&messageID)
+	return messageID, err
+}
+
+// insertDedup inserts message unless message with the same dedup id was
+// inserted within dedup window, id of that message is returned instead.
+// Must be called within transaction.
+func (t *simpleDelayQueue) insertDedup(q queryer, emr api.EnqueueMessageRequest) (int64, error) {
+	window := t.delivery.DeduplicationWindow().Seconds()
+
+	// expired entries are removed in small portions on every insert
+	_, err := q.Exec(fmt.Sprintf(`
+	DELETE FROM queues.%[1]s_dedup
+	WHERE created_at <= NOW() - $2 * interval '1 second'
+		AND (dedup_id = $1 OR dedup_id IN (
+			SELECT dedup_id FROM queues.%[1]s_dedup
+			WHERE created_at <= NOW() - $2 * interval '1 second'
+			LIMIT 100
+		))`, t.table), emr.DedupID, window)
+	if err != nil {
+		return 0, err
+	}
+
+	// concurrent insert of the same dedup id waits here
+	// until the first transaction is finished
+	ct, err := q.Exec(fmt.Sprintf(`
+	INSERT INTO queues.%s_dedup (dedup_id) VALUES ($1)
+	ON CONFLICT (dedup_id) DO NOTHING`, t.table), emr.DedupID)
+	if err != nil {
+		return 0, err
+	}
+
+	var messageID int64
+	if ct.RowsAffected() == 0 {
+		err := q.QueryRow(fmt.Sprintf(
+			`SELECT message_id FROM queues.%s_dedup WHERE dedup_id = $1`, t.table),
+			emr.DedupID).Scan(&messageID)
+		return messageID, err
+	}
+
+	if messageID, err = t.insert(q, emr); err != nil {
+		return 0, err
+	}
+
+	_, err = q.Exec(fmt.Sprintf(
+		`UPDATE queues.%s_dedup SET message_id = $2 WHERE dedup_id = $1`, t.table),
+		emr.DedupID, messageID)
+	return messageID, err
+}
+
+// addBatchDedup inserts messages one by one within a single transaction,
+// it is used for batches with dedup ids.
+func (t *simpleDelayQueue) addBatchDedup(emrs []api.EnqueueMessageRequest) ([]api.MessageID, error) {
+	tx, err := t.pool.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	out := make([]api.MessageID, len(emrs))
+	for i, emr := range emrs {
+		var id int64
+		if emr.DedupID == "" {
+			id, err = t.insert(tx, emr)
+		} else {
+			id, err = t.insertDedup(tx, emr)
+		}
+		if err != nil {
+			return nil, err
+		}
+		out[i] = formatMessageID(id)
+	}
+	return out, tx.Commit()
+}
+
+// CheckMessage rejects binary data for queues with text storage.
+func (t *simpleDelayQueue) CheckMessage(emr api.EnqueueMessageRequest) error {
+	_, err := t.dataArg(emr.Data)
+	return err
+}
+
+// AddBatch inserts all messages with a single statement.
+func (t *simpleDelayQueue) AddBatch(emrs []api.EnqueueMessageRequest) ([]api.MessageID, error) {
+	for _, emr := range emrs {
+		if emr.DedupID != "" {
+			return t.addBatchDedup(emrs)
+		}
+	}
+	texts := make([]string, 0, len(emrs))
+	blobs := make([][]byte, 0, len(emrs))
+	delays := make([]float64, len(emrs))
+	// empty string stands for no scheduled_at and expires_at,
+	// zero ttl stands for no ttl
+	scheduled := make([]string, len(emrs))
+	expires := make([]string, len(emrs))
+	ttls := make([]float64, len(emrs))
+	attributes := make([]string, len(emrs))
+	for i, emr := range emrs {
+		data, err := t.dataArg(emr.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		if t.storage == byteaStorage {
+			blobs = append(blobs, data.([]byte))
+		} else {
+			texts = append(texts, data.(string))
+		}
+
+		delays[i] = emr.Delay.Seconds()
+		if !emr.ScheduledAt.IsZero() {
+			scheduled[i] = emr.ScheduledAt.Format(time.RFC3339Nano)
+		}
+		if !emr.ExpiresAt.IsZero() {
+			expires[i] = emr.ExpiresAt.Format(time.RFC3339Nano)
+		}
+		ttls[i] = emr.ExpiryTTL(t.delivery.DefaultTTL).Seconds()
+		if attributes[i], err = marshalAttributes(emr.Attributes); err != nil {
+			return nil, err
+		}
+	}
+
+	var data interface{} = texts
+	if t.storage == byteaStorage {
+		data = blobs
+	}
+
+	stmt := fmt.Sprintf(`
+	INSERT INTO queues.%s (data, attributes, scheduled_at, visible_at, expires_at)
+	SELECT m.data, m.attributes, m.scheduled_at, m.scheduled_at, m.expires_at
+	FROM (
+		SELECT
+			u.data,
+			NULLIF(u.attributes, '')::jsonb AS attributes,
+			COALESCE(NULLIF(u.scheduled_at, '')::timestamptz, NOW() + u.delay * interval '1 second') AS scheduled_at,
+			COALESCE(NOW() + NULLIF(u.ttl, 0) * interval '1 second', NULLIF(u.expires_at, '')::timestamptz) AS expires_at,
+			u.n
+		FROM unnest($1::%s[], $2::float8[], $3::text[], $4::text[], $5::text[], $6::float8[])
+			WITH ORDINALITY AS u(data, delay, scheduled_at, attributes, expires_at, ttl, n)
+	) m
+	ORDER BY m.n
+	RETURNING message_id`, t.table, t.storage)
+
+	rows, err := t.pool.Query(stmt, data, delays, scheduled, attributes, expires, ttls)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make([]int64, 0, len(emrs))
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Serial values are assigned in insertion order, RETURNING order
+	// is not guaranteed.
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+	out := make([]api.MessageID, len(ids))
+	for i, id := range ids {
+		out[i] = formatMessageID(id)
+	}
+	return out, nil
 }
 
 func (t *simpleDelayQueue) Ack(ackKey string) error {
@@ -190,6 +994,116 @@ func (t *simpleDelayQueue) Ack(ackKey string) error {
 	return nil
 }
 
+// AckBatch deletes acknowledged messages with a single statement,
+// malformed keys are reported as ineffective.
+func (t *simpleDelayQueue) AckBatch(ackKeys []string) ([]string, error) {
+	ineffective := make([]string, 0)
+	ids := make([]int64, 0, len(ackKeys))
+	tokens := make([]string, 0, len(ackKeys))
+	for _, key := range ackKeys {
+		id, token, err := parseAckKey(key)
+		if err != nil {
+			ineffective = append(ineffective, key)
+			continue
+		}
+		ids = append(ids, id)
+		tokens = append(tokens, token)
+	}
+
+	stmt := fmt.Sprintf(`
+	DELETE FROM queues.%s AS original
+	USING unnest($1::bigint[], $2::text[]) AS k(message_id, ack_token)
+	WHERE original.message_id = k.message_id AND original.ack_token = k.ack_token
+	RETURNING original.message_id, original.ack_token`, t.table)
+
+	rows, err := t.pool.Query(stmt, ids, tokens)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acked := make(map[string]bool, len(ids))
+	for rows.Next() {
+		var id int64
+		var token string
+		if err := rows.Scan(&id, &token); err != nil {
+			return nil, err
+		}
+		acked[formatAckKey(id, token)] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range ids {
+		key := formatAckKey(ids[i], tokens[i])
+		if !acked[key] {
+			ineffective = append(ineffective, key)
+		}
+		// repeated key finds message already acknowledged
+		delete(acked, key)
+	}
+	return ineffective, nil
+}
+
+// Nack returns leased message to the queue, message becomes visible
+// after delay. Ack token is reset, so ack key can not be used anymore.
+func (t *simpleDelayQueue) Nack(ackKey string, delay time.Duration, reason string) error {
+	id, token, err := parseAckKey(ackKey)
+	if err != nil {
+		return err
+	}
+
+	args := []interface{}{id, token, nullString(reason)}
+
+	// explicit delay takes precedence over queue retry policy
+	backoff := fmt.Sprintf("$%d * interval '1 second'", len(args)+1)
+	if delay > 0 {
+		args = append(args, delay.Seconds())
+	} else {
+		backoff, args = t.retryBackoffExpr("attempts", args)
+	}
+
+	stmt := fmt.Sprintf(`
+	UPDATE queues.%s
+	SET
+		visible_at = NOW() + %s,
+		ack_token = NULL,
+		last_error = COALESCE($3, last_error)
+	WHERE message_id = $1 AND ack_token = $2`, t.table, backoff)
+	ct, err := t.pool.Exec(stmt, args...)
+	if err != nil {
+		return err
+	}
+
+	if ct.RowsAffected() <= 0 {
+		return errors.New("nack ineffective")
+	}
+	return nil
+}
+
+func (t *simpleDelayQueue) ExtendVisibility(ackKey string, visibility time.Duration) error {
+	id, token, err := parseAckKey(ackKey)
+	if err != nil {
+		return err
+	}
+
+	stmt := fmt.Sprintf(`
+	UPDATE queues.%s
+	SET visible_at = NOW() + $3 * interval '1 second'
+	WHERE message_id = $1 AND ack_token = $2`, t.table)
+	ct, err := t.pool.Exec(stmt, id, token, visibility.Seconds())
+	if err != nil {
+		return err
+	}
+
+	if ct.RowsAffected() <= 0 {
+		return errors.New("extend ineffective")
+	}
+	return nil
+}
+
 func parseAckKey(s string) (int64, string, error) {
 	toks := strings.Split(s, "/")
 	if len(toks) != 2 {
@@ -211,3 +1125,61 @@ func formatAckKey(id int64, token string) string {
 func formatMessageID(id int64) api.MessageID {
 	return api.MessageID(strconv.FormatInt(id, 10))
 }
+
+func parseCursor(cursor api.MessageID) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+
+	id, err := strconv.ParseInt(string(cursor), 10, 64)
+	if err != nil {
+		return 0, errors.Wrap(err, "invalid cursor")
+	}
+	return id, nil
+}
+
+// nullTime maps zero time to SQL NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// marshalAttributes encodes attributes for jsonb column,
+// empty string stands for no attributes.
+func marshalAttributes(attrs api.MessageAttributes) (string, error) {
+	if len(attrs) == 0 {
+		return "", nil
+	}
+
+	b, err := json.Marshal(attrs)
+	return string(b), err
+}
+
+func unmarshalAttributes(b []byte) (api.MessageAttributes, error) {
+	if len(b) == 0 {
+		return nil, nil
+	}
+
+	var attrs api.MessageAttributes
+	err := json.Unmarshal(b, &attrs)
+	return attrs, err
+}
+
+// nullSeconds maps zero duration to SQL NULL, otherwise returns seconds.
+func nullSeconds(d time.Duration) *float64 {
+	if d == 0 {
+		return nil
+	}
+	s := d.Seconds()
+	return &s
+}
+
+// nullString maps empty string to SQL NULL.
+func nullString(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
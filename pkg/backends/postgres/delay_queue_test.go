@@ -0,0 +1,150 @@
+package postgres
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/palestamp/barnacle/pkg/api"
+)
+
+func TestSimpleDelayQueueCheckMessage(t *testing.T) {
+	text := &simpleDelayQueue{storage: textStorage}
+	assert.NoError(t, text.CheckMessage(api.EnqueueMessageRequest{Data: "smaug"}))
+	assert.Equal(t, ErrBinaryData, text.CheckMessage(api.EnqueueMessageRequest{Data: "\xff\x00"}))
+	assert.Equal(t, ErrBinaryData, text.CheckMessage(api.EnqueueMessageRequest{Data: "smaug\x00"}))
+
+	bytea := &simpleDelayQueue{storage: byteaStorage}
+	assert.NoError(t, bytea.CheckMessage(api.EnqueueMessageRequest{Data: "\xff\x00"}))
+}
+
+// testQueue creates queue with given table in test database,
+// test is skipped when database is not available.
+func testQueue(t *testing.T, table string, ops api.QueueOptions) (api.Queue, func()) {
+	conn := testConn(t)
+	defer conn.Close()
+	testExec(t, conn,
+		`CREATE SCHEMA IF NOT EXISTS queues`,
+		fmt.Sprintf(`DROP TABLE IF EXISTS queues.%[1]s, queues.%[1]s_dedup, queues.%[1]s_counters`, table),
+	)
+
+	backend, err := NewConnector().Connect(api.ResourceID("test"), api.ResourceConnOptions{"uri": testDbURI})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manager, err := backend.GetQueueManager(api.SimpleDelayQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	qops := api.QueueOptions{"table": table}
+	for k, v := range ops {
+		qops[k] = v
+	}
+
+	qm := api.QueueMetadata{QueueID: api.QueueID(table), Options: qops}
+	if err := manager.CreateQueue(api.RegisterQueueRequest{QueueID: qm.QueueID, Options: qops}); err != nil {
+		t.Fatal(err)
+	}
+
+	queue, err := manager.ConnectToQueue(qm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return queue, func() { manager.DeleteQueue(qm, true) }
+}
+
+func testPoll(t *testing.T, queue api.Queue, visibility time.Duration) []api.Message {
+	msgs, err := queue.Poll(api.PollRequest{
+		Limit:      10,
+		Deadline:   time.Now().Add(time.Second),
+		Visibility: visibility,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func TestSimpleDelayQueueAckBatchDuplicateKeys(t *testing.T) {
+	queue, cleanup := testQueue(t, "ut_ack_batch", nil)
+	defer cleanup()
+
+	_, err := queue.Add(api.EnqueueMessageRequest{QueueID: "ut_ack_batch", Data: "smaug"})
+	assert.NoError(t, err)
+
+	msgs := testPoll(t, queue, time.Minute)
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+
+	ineffective, err := queue.AckBatch([]string{msgs[0].AckKey, msgs[0].AckKey})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{msgs[0].AckKey}, ineffective)
+}
+
+func TestSimpleDelayQueueRescheduleExpiredLease(t *testing.T) {
+	queue, cleanup := testQueue(t, "ut_reschedule", nil)
+	defer cleanup()
+
+	id, err := queue.Add(api.EnqueueMessageRequest{QueueID: "ut_reschedule", Data: "smaug"})
+	assert.NoError(t, err)
+
+	msgs := testPoll(t, queue, 50*time.Millisecond)
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, queue.Reschedule(api.RescheduleRequest{ID: id, Delay: api.Delay{Duration: time.Minute}}))
+
+	// rescheduled message is delayed, not leased to previous consumer.
+	assert.Error(t, queue.Ack(msgs[0].AckKey))
+	pm, err := queue.Get(id)
+	assert.NoError(t, err)
+	assert.Equal(t, api.DelayedMessageState, pm.State)
+}
+
+func TestDelayQueueOptionsTableName(t *testing.T) {
+	for table, expected := range map[string]error{
+		"erebor":            nil,
+		"erebor_2019":       nil,
+		"":                  ErrTableNameInvalid,
+		"Erebor":            ErrTableNameInvalid,
+		"erebor; drop":      ErrTableNameInvalid,
+		"erebor_dedup":      ErrTableNameReserved,
+		"erebor_counters":   ErrTableNameReserved,
+		"erebor_dedup_2019": nil,
+	} {
+		ops := delayQueueOptions{Table: table, Storage: textStorage}
+		assert.Equal(t, expected, ops.Validate(), table)
+	}
+}
+
+func TestDelayQueueManagerDeleteKeepsForeignTables(t *testing.T) {
+	_, cleanup := testQueue(t, "ut_companion", nil)
+
+	conn := testConn(t)
+	defer conn.Close()
+
+	// table of another queue named like companion table, possible for
+	// queues created before suffixes were reserved.
+	testExec(t, conn,
+		`DROP TABLE queues.ut_companion_dedup`,
+		`CREATE TABLE queues.ut_companion_dedup (message_id bigint, data text)`,
+	)
+	defer testExec(t, conn, `DROP TABLE IF EXISTS queues.ut_companion_dedup`)
+
+	cleanup()
+
+	var exists, counters bool
+	assert.NoError(t, conn.QueryRow(`
+	SELECT
+		to_regclass('queues.ut_companion_dedup') IS NOT NULL,
+		to_regclass('queues.ut_companion_counters') IS NOT NULL`).Scan(&exists, &counters))
+	assert.True(t, exists)
+	assert.False(t, counters)
+}
@@ -28,6 +28,13 @@ func (rs *Registry) RegisterConnector(t api.BackendType, connector api.Connector
 	rs.connectors[t] = connector
 }
 
+// InvalidateResource drops backends cached for resource by all connectors.
+func (rs *Registry) InvalidateResource(rid api.ResourceID) {
+	for _, connector := range rs.connectors {
+		connector.Invalidate(rid)
+	}
+}
+
 func (rs *Registry) Connector(t api.BackendType) (api.Connector, error) {
 	connector, ok := rs.connectors[t]
 	if !ok {
@@ -7,6 +7,7 @@ import (
 
 	"github.com/palestamp/barnacle/pkg/api"
 	"github.com/palestamp/barnacle/pkg/backends"
+	"github.com/palestamp/barnacle/pkg/backends/memory"
 	"github.com/palestamp/barnacle/pkg/backends/postgres"
 )
 
@@ -23,3 +24,19 @@ func TestConnector(t *testing.T) {
 	assert.NoError(t, err)
 
 }
+
+func TestMemoryConnector(t *testing.T) {
+	registry := backends.NewRegistry()
+	registry.RegisterConnector(api.BackendType("memory"), memory.NewConnector())
+
+	connector, err := registry.Connector(api.BackendType("memory"))
+	assert.NoError(t, err)
+
+	_, err = connector.Connect(api.ResourceID("test"), api.ResourceConnOptions{})
+	assert.NoError(t, err)
+
+	_, err = connector.Connect(api.ResourceID("test"), api.ResourceConnOptions{
+		"uri": "memory://",
+	})
+	assert.Error(t, err)
+}
@@ -2,7 +2,9 @@ package decode
 
 import (
 	"fmt"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
@@ -11,8 +13,9 @@ import (
 func Decode(from interface{}, into interface{}) error {
 	var m mapstructure.Metadata
 	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
-		Result:   into,
-		Metadata: &m,
+		Result:     into,
+		Metadata:   &m,
+		DecodeHook: durationHook,
 	})
 	if err != nil {
 		return errors.WithMessage(err, "resource decoding failed")
@@ -29,3 +32,25 @@ func Decode(from interface{}, into interface{}) error {
 
 	return nil
 }
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// durationHook decodes time.Duration fields either from Go duration
+// notation or from number of seconds, same as api.Delay does.
+func durationHook(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+	if to != durationType {
+		return data, nil
+	}
+
+	switch v := data.(type) {
+	case string:
+		return time.ParseDuration(v)
+	case float64:
+		return time.Duration(v * float64(time.Second)), nil
+	case int:
+		return time.Duration(v) * time.Second, nil
+	case int64:
+		return time.Duration(v) * time.Second, nil
+	}
+	return data, nil
+}
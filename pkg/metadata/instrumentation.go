@@ -20,9 +20,14 @@ func (l *logging) RegisterQueueMetadata(rqr api.RegisterQueueRequest) error {
 	return l.next.RegisterQueueMetadata(rqr)
 }
 
-func (l *logging) SetQueueState(qid api.QueueID, state api.QueueState) error {
-	log.Printf("MetadataStorage.SetQueueState [qid=%s; state=%s]", qid, state)
-	return l.next.SetQueueState(qid, state)
+func (l *logging) SetQueueState(qid api.QueueID, state api.QueueState, lastError string) error {
+	log.Printf("MetadataStorage.SetQueueState [qid=%s; state=%s; err=%s]", qid, state, lastError)
+	return l.next.SetQueueState(qid, state, lastError)
+}
+
+func (l *logging) QueueHistory(qid api.QueueID) ([]api.QueueTransition, error) {
+	log.Printf("MetadataStorage.QueueHistory [qid=%s]", qid)
+	return l.next.QueueHistory(qid)
 }
 
 func (l *logging) DeleteQueueMetadata(qid api.QueueID) error {
@@ -36,7 +41,32 @@ func (l *logging) GetQueueMetadata(qid api.QueueID, allowedStates ...api.QueueSt
 	return l.next.GetQueueMetadata(qid, allowedStates...)
 }
 
+func (l *logging) ListQueues(after api.QueueID, limit int) ([]api.QueueMetadata, error) {
+	log.Printf("MetadataStorage.ListQueues [after=%s; limit=%d]", after, limit)
+	return l.next.ListQueues(after, limit)
+}
+
 func (l *logging) RegisterResource(rm api.ResourceMetadata) error {
 	log.Printf("MetadataStorage.RegisterResource [rid=%s]", rm.ResourceID)
 	return l.next.RegisterResource(rm)
 }
+
+func (l *logging) GetResource(rid api.ResourceID) (api.ResourceMetadata, error) {
+	log.Printf("MetadataStorage.GetResource [rid=%s]", rid)
+	return l.next.GetResource(rid)
+}
+
+func (l *logging) ListResources(after api.ResourceID, limit int) ([]api.ResourceMetadata, error) {
+	log.Printf("MetadataStorage.ListResources [after=%s; limit=%d]", after, limit)
+	return l.next.ListResources(after, limit)
+}
+
+func (l *logging) UpdateResource(rm api.ResourceMetadata) error {
+	log.Printf("MetadataStorage.UpdateResource [rid=%s]", rm.ResourceID)
+	return l.next.UpdateResource(rm)
+}
+
+func (l *logging) DeleteResource(rid api.ResourceID) error {
+	log.Printf("MetadataStorage.DeleteResource [rid=%s]", rid)
+	return l.next.DeleteResource(rid)
+}
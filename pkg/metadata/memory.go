@@ -0,0 +1,342 @@
+package metadata
+
+import (
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/palestamp/barnacle/pkg/api"
+)
+
+var (
+	// ErrQueueExists ...
+	ErrQueueExists = errors.New("queue already exists")
+	// ErrResourceNotFound ...
+	ErrResourceNotFound = errors.New("resource not found")
+	// ErrResourceExists ...
+	ErrResourceExists = errors.New("resource already exists")
+	// ErrResourceInUse - resource hosts queues and can not be deleted.
+	ErrResourceInUse = errors.New("resource hosts queues")
+)
+
+var _ api.MetadataStorage = (*MemoryMetadataStorage)(nil)
+
+// MemoryMetadataStorage keeps metadata in process memory,
+// it follows PostgresMetadataStorage semantics.
+type MemoryMetadataStorage struct {
+	mu        sync.RWMutex
+	queues    map[api.QueueID]memoryQueueRecord
+	resources map[api.ResourceID]memoryResourceRecord
+}
+
+type memoryResourceRecord struct {
+	backendType api.BackendType
+	config      []byte
+}
+
+type memoryQueueRecord struct {
+	resourceID  api.ResourceID
+	backendType api.BackendType
+	queueType   api.QueueType
+	queueState  api.QueueState
+	config      []byte
+
+	createdAt      time.Time
+	stateChangedAt time.Time
+	lastError      string
+	history        []api.QueueTransition
+}
+
+func (rec *memoryQueueRecord) metadata(qid api.QueueID, qps api.QueueOptions) api.QueueMetadata {
+	return api.QueueMetadata{
+		QueueID:        qid,
+		ResourceID:     rec.resourceID,
+		BackendType:    rec.backendType,
+		QueueType:      rec.queueType,
+		QueueState:     rec.queueState,
+		Options:        qps,
+		CreatedAt:      rec.createdAt,
+		StateChangedAt: rec.stateChangedAt,
+		LastError:      rec.lastError,
+	}
+}
+
+func NewMemoryStorage() *MemoryMetadataStorage {
+	return &MemoryMetadataStorage{
+		queues:    make(map[api.QueueID]memoryQueueRecord),
+		resources: make(map[api.ResourceID]memoryResourceRecord),
+	}
+}
+
+func (s *MemoryMetadataStorage) RegisterQueueMetadata(qmi api.RegisterQueueRequest) error {
+	// Options are stored serialized, the same way postgres stores JSONB,
+	// so callers never share maps with storage.
+	b, err := json.Marshal(qmi.Options)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.queues[qmi.QueueID]; ok {
+		return errors.Wrap(ErrQueueExists, "queue registration failed")
+	}
+
+	if _, ok := s.resources[qmi.ResourceID]; !ok {
+		return errors.Wrap(ErrResourceNotFound, "queue registration failed")
+	}
+
+	now := time.Now()
+	s.queues[qmi.QueueID] = memoryQueueRecord{
+		resourceID:     qmi.ResourceID,
+		backendType:    qmi.BackendType,
+		queueType:      qmi.QueueType,
+		queueState:     api.ProvisioningQueueState,
+		config:         b,
+		createdAt:      now,
+		stateChangedAt: now,
+		history: []api.QueueTransition{
+			{To: api.ProvisioningQueueState, At: now},
+		},
+	}
+	return nil
+}
+
+func (s *MemoryMetadataStorage) SetQueueState(qid api.QueueID, state api.QueueState, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.queues[qid]
+	if !ok {
+		return ErrQueueNotFound
+	}
+
+	if err := checkTransition(rec.queueState, state); err != nil {
+		return err
+	}
+
+	if rec.queueState == state && lastError == "" {
+		return nil
+	}
+
+	now := time.Now()
+	rec.history = append(rec.history, api.QueueTransition{
+		From:  rec.queueState,
+		To:    state,
+		At:    now,
+		Error: lastError,
+	})
+	rec.queueState = state
+	rec.stateChangedAt = now
+	if lastError != "" {
+		rec.lastError = lastError
+	}
+	s.queues[qid] = rec
+	return nil
+}
+
+func (s *MemoryMetadataStorage) QueueHistory(qid api.QueueID) ([]api.QueueTransition, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.queues[qid]
+	if !ok {
+		return nil, ErrQueueNotFound
+	}
+
+	out := make([]api.QueueTransition, len(rec.history))
+	copy(out, rec.history)
+	return out, nil
+}
+
+func (s *MemoryMetadataStorage) DeleteQueueMetadata(qid api.QueueID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.queues, qid)
+	return nil
+}
+
+func (s *MemoryMetadataStorage) GetQueueMetadata(qid api.QueueID, allowedStates ...api.QueueState) (api.QueueMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.queues[qid]
+	if !ok || !stateAllowed(rec.queueState, allowedStates) {
+		return api.QueueMetadata{}, ErrQueueNotFound
+	}
+
+	var qps api.QueueOptions
+	var rps api.ResourceConnOptions
+
+	if err := json.Unmarshal(rec.config, &qps); err != nil {
+		return api.QueueMetadata{}, err
+	}
+
+	if err := json.Unmarshal(s.resources[rec.resourceID].config, &rps); err != nil {
+		return api.QueueMetadata{}, err
+	}
+
+	qm := rec.metadata(qid, qps)
+	qm.ConnOptions = rps
+	return qm, nil
+}
+
+func (s *MemoryMetadataStorage) ListQueues(after api.QueueID, limit int) ([]api.QueueMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.queues))
+	for qid := range s.queues {
+		if qid > after {
+			ids = append(ids, string(qid))
+		}
+	}
+	sort.Strings(ids)
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	out := make([]api.QueueMetadata, 0, len(ids))
+	for _, id := range ids {
+		rec := s.queues[api.QueueID(id)]
+
+		var qps api.QueueOptions
+		if err := json.Unmarshal(rec.config, &qps); err != nil {
+			return nil, err
+		}
+
+		out = append(out, rec.metadata(api.QueueID(id), qps))
+	}
+	return out, nil
+}
+
+func (s *MemoryMetadataStorage) RegisterResource(rm api.ResourceMetadata) error {
+	b, err := json.Marshal(rm.ConnOptions)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.resources[rm.ResourceID]; ok {
+		return errors.Wrap(ErrResourceExists, "resource configuration persist call failed")
+	}
+
+	s.resources[rm.ResourceID] = memoryResourceRecord{
+		backendType: rm.BackendType,
+		config:      b,
+	}
+	return nil
+}
+
+func (s *MemoryMetadataStorage) GetResource(rid api.ResourceID) (api.ResourceMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rec, ok := s.resources[rid]
+	if !ok {
+		return api.ResourceMetadata{}, ErrResourceNotFound
+	}
+
+	var rps api.ResourceConnOptions
+	if err := json.Unmarshal(rec.config, &rps); err != nil {
+		return api.ResourceMetadata{}, err
+	}
+	return api.ResourceMetadata{ResourceID: rid, BackendType: rec.backendType, ConnOptions: rps}, nil
+}
+
+func (s *MemoryMetadataStorage) ListResources(after api.ResourceID, limit int) ([]api.ResourceMetadata, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	ids := make([]string, 0, len(s.resources))
+	for rid := range s.resources {
+		if rid > after {
+			ids = append(ids, string(rid))
+		}
+	}
+	sort.Strings(ids)
+
+	if len(ids) > limit {
+		ids = ids[:limit]
+	}
+
+	out := make([]api.ResourceMetadata, 0, len(ids))
+	for _, id := range ids {
+		rec := s.resources[api.ResourceID(id)]
+
+		var rps api.ResourceConnOptions
+		if err := json.Unmarshal(rec.config, &rps); err != nil {
+			return nil, err
+		}
+
+		out = append(out, api.ResourceMetadata{
+			ResourceID:  api.ResourceID(id),
+			BackendType: rec.backendType,
+			ConnOptions: rps,
+		})
+	}
+	return out, nil
+}
+
+func (s *MemoryMetadataStorage) UpdateResource(rm api.ResourceMetadata) error {
+	b, err := json.Marshal(rm.ConnOptions)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rec, ok := s.resources[rm.ResourceID]
+	if !ok {
+		return errors.Wrap(ErrResourceNotFound, "resource update failed")
+	}
+
+	// backend type of resource can not be changed
+	rec.config = b
+	s.resources[rm.ResourceID] = rec
+	return nil
+}
+
+func (s *MemoryMetadataStorage) DeleteResource(rid api.ResourceID) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.resources[rid]; !ok {
+		return errors.Wrap(ErrResourceNotFound, "resource deletion failed")
+	}
+
+	for _, rec := range s.queues {
+		if rec.resourceID == rid {
+			return errors.Wrap(ErrResourceInUse, "resource deletion failed")
+		}
+	}
+
+	delete(s.resources, rid)
+	return nil
+}
+
+func stateAllowed(state api.QueueState, allowed []api.QueueState) bool {
+	for _, s := range allowed {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTransition is shared by storages, so both enforce the same lifecycle.
+func checkTransition(from, to api.QueueState) error {
+	if !from.CanTransitionTo(to) {
+		return errors.Wrapf(api.ErrQueueStateTransition, "queue state change from %s to %s failed", from, to)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package metadata_test
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/palestamp/barnacle/pkg/api"
+	"github.com/palestamp/barnacle/pkg/metadata"
+)
+
+func TestMemoryStorageQueueMetadata(t *testing.T) {
+	s := metadata.NewMemoryStorage()
+
+	rqr := api.RegisterQueueRequest{
+		QueueID:     "erebor",
+		ResourceID:  "main",
+		BackendType: "memory",
+		QueueType:   api.SimpleDelayQueue,
+		Options:     api.QueueOptions{"table": "erebor"},
+	}
+
+	// resource must exist before queue registration.
+	assert.Error(t, s.RegisterQueueMetadata(rqr))
+
+	assert.NoError(t, s.RegisterResource(api.ResourceMetadata{ResourceID: "main"}))
+	assert.Error(t, s.RegisterResource(api.ResourceMetadata{ResourceID: "main"}))
+
+	assert.NoError(t, s.RegisterQueueMetadata(rqr))
+	assert.Error(t, s.RegisterQueueMetadata(rqr))
+
+	_, err := s.GetQueueMetadata("erebor", api.ActiveQueueState)
+	assert.Equal(t, metadata.ErrQueueNotFound, err)
+
+	qm, err := s.GetQueueMetadata("erebor", api.ProvisioningQueueState)
+	assert.NoError(t, err)
+	assert.Equal(t, api.ProvisioningQueueState, qm.QueueState)
+	assert.Equal(t, api.QueueOptions{"table": "erebor"}, qm.Options)
+
+	// returned options are detached from storage.
+	qm.Options["table"] = "moria"
+
+	assert.NoError(t, s.SetQueueState("erebor", api.ActiveQueueState, ""))
+	qm, err = s.GetQueueMetadata("erebor", api.ActiveQueueState)
+	assert.NoError(t, err)
+	assert.Equal(t, api.QueueOptions{"table": "erebor"}, qm.Options)
+
+	assert.NoError(t, s.DeleteQueueMetadata("erebor"))
+	_, err = s.GetQueueMetadata("erebor", api.ActiveQueueState)
+	assert.Equal(t, metadata.ErrQueueNotFound, err)
+}
+
+func TestMemoryStorageQueueLifecycle(t *testing.T) {
+	s := metadata.NewMemoryStorage()
+	assert.NoError(t, s.RegisterResource(api.ResourceMetadata{ResourceID: "main"}))
+	assert.NoError(t, s.RegisterQueueMetadata(api.RegisterQueueRequest{QueueID: "erebor", ResourceID: "main"}))
+
+	err := s.SetQueueState("erebor", api.PausedQueueState, "")
+	assert.Equal(t, api.ErrQueueStateTransition, errors.Cause(err))
+
+	assert.NoError(t, s.SetQueueState("erebor", api.FailedQueueState, "table exists"))
+	err = s.SetQueueState("erebor", api.ActiveQueueState, "")
+	assert.Equal(t, api.ErrQueueStateTransition, errors.Cause(err))
+
+	// the same state is not recorded twice.
+	assert.NoError(t, s.SetQueueState("erebor", api.FailedQueueState, ""))
+	assert.NoError(t, s.SetQueueState("erebor", api.DeletingQueueState, ""))
+
+	qm, err := s.GetQueueMetadata("erebor", api.DeletingQueueState)
+	assert.NoError(t, err)
+	assert.Equal(t, "table exists", qm.LastError)
+	assert.False(t, qm.CreatedAt.After(qm.StateChangedAt))
+
+	history, err := s.QueueHistory("erebor")
+	assert.NoError(t, err)
+	if assert.Len(t, history, 3) {
+		assert.Equal(t, api.QueueState(""), history[0].From)
+		assert.Equal(t, api.ProvisioningQueueState, history[0].To)
+		assert.Equal(t, api.FailedQueueState, history[1].To)
+		assert.Equal(t, "table exists", history[1].Error)
+		assert.Equal(t, api.FailedQueueState, history[2].From)
+		assert.Equal(t, api.DeletingQueueState, history[2].To)
+	}
+
+	assert.NoError(t, s.DeleteQueueMetadata("erebor"))
+	_, err = s.QueueHistory("erebor")
+	assert.Equal(t, metadata.ErrQueueNotFound, err)
+	assert.Equal(t, metadata.ErrQueueNotFound, s.SetQueueState("erebor", api.ActiveQueueState, ""))
+}
+
+func TestMemoryStorageResources(t *testing.T) {
+	s := metadata.NewMemoryStorage()
+
+	assert.NoError(t, s.RegisterResource(api.ResourceMetadata{ResourceID: "main"}))
+	assert.NoError(t, s.RegisterResource(api.ResourceMetadata{ResourceID: "backup"}))
+
+	rms, err := s.ListResources("", 10)
+	assert.NoError(t, err)
+	if assert.Len(t, rms, 2) {
+		assert.Equal(t, api.ResourceID("backup"), rms[0].ResourceID)
+	}
+
+	rms, err = s.ListResources("backup", 10)
+	assert.NoError(t, err)
+	assert.Len(t, rms, 1)
+
+	assert.NoError(t, s.UpdateResource(api.ResourceMetadata{
+		ResourceID:  "main",
+		ConnOptions: api.ResourceConnOptions{"uri": "postgresql://localhost/barnacle"},
+	}))
+	assert.Error(t, s.UpdateResource(api.ResourceMetadata{ResourceID: "mordor"}))
+
+	rm, err := s.GetResource("main")
+	assert.NoError(t, err)
+	assert.Equal(t, api.ResourceConnOptions{"uri": "postgresql://localhost/barnacle"}, rm.ConnOptions)
+
+	assert.NoError(t, s.RegisterQueueMetadata(api.RegisterQueueRequest{QueueID: "erebor", ResourceID: "main"}))
+
+	err = s.DeleteResource("main")
+	assert.Equal(t, metadata.ErrResourceInUse, errors.Cause(err))
+
+	assert.NoError(t, s.DeleteQueueMetadata("erebor"))
+	assert.NoError(t, s.DeleteResource("main"))
+
+	_, err = s.GetResource("main")
+	assert.Equal(t, metadata.ErrResourceNotFound, err)
+	assert.Error(t, s.DeleteResource("main"))
+}
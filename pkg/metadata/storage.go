@@ -2,6 +2,7 @@ package metadata
 
 import (
 	"encoding/json"
+	"time"
 
 	"github.com/jackc/pgx"
 	"github.com/pkg/errors"
@@ -42,7 +43,13 @@ func (s *PostgresMetadataStorage) RegisterQueueMetadata(qmi api.RegisterQueueReq
 		return err
 	}
 
-	_, err = s.pool.Exec(
+	tx, err := s.pool.Begin()
+	if err != nil {
+		return errors.Wrap(err, "queue registration failed")
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(
 		`insert into barnacle.queue_configs (
 			queue_id,
 			resource_id,
@@ -50,17 +57,111 @@ func (s *PostgresMetadataStorage) RegisterQueueMetadata(qmi api.RegisterQueueReq
 			queue_type,
 			config,
 			queue_state
-		) values ($1, $2, $3, $4, $5, 'inactive')`,
+		) values ($1, $2, $3, $4, $5, 'provisioning')`,
 		qmi.QueueID, qmi.ResourceID, qmi.BackendType, qmi.QueueType, b)
-	return errors.Wrap(err, "queue registration failed")
+	if err != nil {
+		return errors.Wrap(err, "queue registration failed")
+	}
+
+	_, err = tx.Exec(
+		`insert into barnacle.queue_state_history (queue_id, to_state)
+			values ($1, 'provisioning')`, qmi.QueueID)
+	if err != nil {
+		return errors.Wrap(err, "queue registration failed")
+	}
+
+	return errors.Wrap(tx.Commit(), "queue registration failed")
 }
 
-func (s *PostgresMetadataStorage) SetQueueState(qid api.QueueID, state api.QueueState) error {
-	_, err := s.pool.Exec(
+func (s *PostgresMetadataStorage) SetQueueState(qid api.QueueID, state api.QueueState, lastError string) error {
+	tx, err := s.pool.Begin()
+	if err != nil {
+		return errors.Wrap(err, "queue state change failed")
+	}
+	defer tx.Rollback()
+
+	// row lock serializes concurrent transitions of the same queue
+	var current string
+	err = tx.QueryRow(
+		`select queue_state from barnacle.queue_configs
+			where queue_id = $1
+			for update`, qid).Scan(&current)
+	if err == pgx.ErrNoRows {
+		return ErrQueueNotFound
+	}
+	if err != nil {
+		return errors.Wrap(err, "queue state change failed")
+	}
+
+	if err := checkTransition(api.QueueState(current), state); err != nil {
+		return err
+	}
+
+	if api.QueueState(current) == state && lastError == "" {
+		return nil
+	}
+
+	_, err = tx.Exec(
 		`update barnacle.queue_configs
-			set queue_state = $1
-			where queue_id = $2`, string(state), qid)
-	return errors.Wrap(err, "queue state change failed")
+			set
+				queue_state = $1,
+				state_changed_at = now(),
+				last_error = coalesce(nullif($3, ''), last_error)
+			where queue_id = $2`, string(state), qid, lastError)
+	if err != nil {
+		return errors.Wrap(err, "queue state change failed")
+	}
+
+	_, err = tx.Exec(
+		`insert into barnacle.queue_state_history (queue_id, from_state, to_state, error)
+			values ($1, $2, $3, nullif($4, ''))`, qid, current, string(state), lastError)
+	if err != nil {
+		return errors.Wrap(err, "queue state change failed")
+	}
+
+	return errors.Wrap(tx.Commit(), "queue state change failed")
+}
+
+func (s *PostgresMetadataStorage) QueueHistory(qid api.QueueID) ([]api.QueueTransition, error) {
+	var exists bool
+	err := s.pool.QueryRow(
+		`select exists (select 1 from barnacle.queue_configs where queue_id = $1)`,
+		qid).Scan(&exists)
+	if err != nil {
+		return nil, errors.Wrap(err, "queue history listing failed")
+	}
+	if !exists {
+		return nil, ErrQueueNotFound
+	}
+
+	rows, err := s.pool.Query(`
+		select
+			coalesce(from_state, ''),
+			to_state,
+			changed_at,
+			coalesce(error, '')
+		from barnacle.queue_state_history
+		where queue_id = $1
+		order by id`, qid)
+	if err != nil {
+		return nil, errors.Wrap(err, "queue history listing failed")
+	}
+	defer rows.Close()
+
+	out := make([]api.QueueTransition, 0)
+	for rows.Next() {
+		var from, to, lastError string
+		var qt api.QueueTransition
+		if err := rows.Scan(&from, &to, &qt.At, &lastError); err != nil {
+			return nil, err
+		}
+
+		qt.From = api.QueueState(from)
+		qt.To = api.QueueState(to)
+		qt.Error = lastError
+		out = append(out, qt)
+	}
+	return out, errors.Wrap(rows.Err(), "queue history listing failed")
 }
 
 func (s *PostgresMetadataStorage) DeleteQueueMetadata(qid api.QueueID) error {
@@ -79,7 +180,10 @@ func (s *PostgresMetadataStorage) GetQueueMetadata(qid api.QueueID, allowedState
 			qc.queue_type,
 			qc.queue_state,
 			qc.config,
-			rc.config
+			rc.config,
+			qc.created_at,
+			qc.state_changed_at,
+			coalesce(qc.last_error, '')
 		from barnacle.queue_configs as qc
 		join barnacle.resource_configs as rc using(resource_id)
 		where qc.queue_id = $1 and qc.queue_state = ANY($2)`, qid, states)
@@ -87,6 +191,8 @@ func (s *PostgresMetadataStorage) GetQueueMetadata(qid api.QueueID, allowedState
 	var (
 		queueID, resourceID, backendType, queueType, queueState string
 		queueConfig, resourceConfig                             []byte
+		createdAt, stateChangedAt                               time.Time
+		lastError                                               string
 	)
 	err := row.Scan(
 		&queueID,
@@ -95,7 +201,10 @@ func (s *PostgresMetadataStorage) GetQueueMetadata(qid api.QueueID, allowedState
 		&queueType,
 		&queueState,
 		&queueConfig,
-		&resourceConfig)
+		&resourceConfig,
+		&createdAt,
+		&stateChangedAt,
+		&lastError)
 
 	if err != nil {
 		return api.QueueMetadata{}, ErrQueueNotFound
@@ -122,9 +231,76 @@ func (s *PostgresMetadataStorage) GetQueueMetadata(qid api.QueueID, allowedState
 		QueueState:  api.QueueState(queueState),
 		Options:     qps,
 		ConnOptions: rps,
+
+		CreatedAt:      createdAt,
+		StateChangedAt: stateChangedAt,
+		LastError:      lastError,
 	}, nil
 }
 
+func (s *PostgresMetadataStorage) ListQueues(after api.QueueID, limit int) ([]api.QueueMetadata, error) {
+	rows, err := s.pool.Query(`
+		select
+			queue_id,
+			resource_id,
+			backend_type,
+			queue_type,
+			queue_state,
+			config,
+			created_at,
+			state_changed_at,
+			coalesce(last_error, '')
+		from barnacle.queue_configs
+		where queue_id > $1
+		order by queue_id
+		limit $2`, after, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "queue listing failed")
+	}
+	defer rows.Close()
+
+	out := make([]api.QueueMetadata, 0)
+	for rows.Next() {
+		var (
+			queueID, resourceID, backendType, queueType, queueState string
+			queueConfig                                             []byte
+			createdAt, stateChangedAt                               time.Time
+			lastError                                               string
+		)
+		if err := rows.Scan(
+			&queueID,
+			&resourceID,
+			&backendType,
+			&queueType,
+			&queueState,
+			&queueConfig,
+			&createdAt,
+			&stateChangedAt,
+			&lastError); err != nil {
+			return nil, err
+		}
+
+		var qps api.QueueOptions
+		if err := json.Unmarshal(queueConfig, &qps); err != nil {
+			return nil, err
+		}
+
+		out = append(out, api.QueueMetadata{
+			QueueID:     api.QueueID(queueID),
+			ResourceID:  api.ResourceID(resourceID),
+			BackendType: api.BackendType(backendType),
+			QueueType:   api.QueueType(queueType),
+			QueueState:  api.QueueState(queueState),
+			Options:     qps,
+
+			CreatedAt:      createdAt,
+			StateChangedAt: stateChangedAt,
+			LastError:      lastError,
+		})
+	}
+	return out, errors.Wrap(rows.Err(), "queue listing failed")
+}
+
 func (s *PostgresMetadataStorage) RegisterResource(rm api.ResourceMetadata) error {
 	b, err := json.Marshal(rm.ConnOptions)
 	if err != nil {
@@ -132,11 +308,112 @@ func (s *PostgresMetadataStorage) RegisterResource(rm api.ResourceMetadata) erro
 	}
 
 	_, err = s.pool.Exec(
-		`insert into barnacle.resource_configs (resource_id, config) values ($1, $2)`,
-		rm.ResourceID, b)
+		`insert into barnacle.resource_configs (resource_id, backend_type, config) values ($1, $2, $3)`,
+		rm.ResourceID, rm.BackendType, b)
 	return errors.Wrap(err, "resource configuration persist call failed")
 }
 
+func (s *PostgresMetadataStorage) GetResource(rid api.ResourceID) (api.ResourceMetadata, error) {
+	var backendType string
+	var config []byte
+	err := s.pool.QueryRow(
+		`select backend_type, config from barnacle.resource_configs where resource_id = $1`,
+		rid).Scan(&backendType, &config)
+	if err == pgx.ErrNoRows {
+		return api.ResourceMetadata{}, ErrResourceNotFound
+	}
+	if err != nil {
+		return api.ResourceMetadata{}, errors.Wrap(err, "resource lookup failed")
+	}
+
+	var rps api.ResourceConnOptions
+	if err := json.Unmarshal(config, &rps); err != nil {
+		return api.ResourceMetadata{}, err
+	}
+	return api.ResourceMetadata{
+		ResourceID:  rid,
+		BackendType: api.BackendType(backendType),
+		ConnOptions: rps,
+	}, nil
+}
+
+func (s *PostgresMetadataStorage) ListResources(after api.ResourceID, limit int) ([]api.ResourceMetadata, error) {
+	rows, err := s.pool.Query(`
+		select resource_id, backend_type, config
+		from barnacle.resource_configs
+		where resource_id > $1
+		order by resource_id
+		limit $2`, after, limit)
+	if err != nil {
+		return nil, errors.Wrap(err, "resource listing failed")
+	}
+	defer rows.Close()
+
+	out := make([]api.ResourceMetadata, 0)
+	for rows.Next() {
+		var resourceID, backendType string
+		var config []byte
+		if err := rows.Scan(&resourceID, &backendType, &config); err != nil {
+			return nil, err
+		}
+
+		var rps api.ResourceConnOptions
+		if err := json.Unmarshal(config, &rps); err != nil {
+			return nil, err
+		}
+		out = append(out, api.ResourceMetadata{
+			ResourceID:  api.ResourceID(resourceID),
+			BackendType: api.BackendType(backendType),
+			ConnOptions: rps,
+		})
+	}
+	return out, errors.Wrap(rows.Err(), "resource listing failed")
+}
+
+func (s *PostgresMetadataStorage) UpdateResource(rm api.ResourceMetadata) error {
+	b, err := json.Marshal(rm.ConnOptions)
+	if err != nil {
+		return err
+	}
+
+	ct, err := s.pool.Exec(
+		`update barnacle.resource_configs set config = $2 where resource_id = $1`,
+		rm.ResourceID, b)
+	if err != nil {
+		return errors.Wrap(err, "resource update failed")
+	}
+
+	if ct.RowsAffected() == 0 {
+		return errors.Wrap(ErrResourceNotFound, "resource update failed")
+	}
+	return nil
+}
+
+func (s *PostgresMetadataStorage) DeleteResource(rid api.ResourceID) error {
+	var deleted, inUse bool
+	err := s.pool.QueryRow(`
+		with hosted as (
+			select exists (select 1 from barnacle.queue_configs where resource_id = $1) as in_use
+		), deleted as (
+			delete from barnacle.resource_configs
+			where resource_id = $1 and not (select in_use from hosted)
+			returning resource_id
+		)
+		select exists (select 1 from deleted), (select in_use from hosted)`, rid).Scan(&deleted, &inUse)
+	if err != nil {
+		return errors.Wrap(err, "resource deletion failed")
+	}
+
+	if inUse {
+		return errors.Wrap(ErrResourceInUse, "resource deletion failed")
+	}
+
+	if !deleted {
+		return errors.Wrap(ErrResourceNotFound, "resource deletion failed")
+	}
+	return nil
+}
+
 func statesSliceToStringSlice(els []api.QueueState) []string {
 	out := make([]string, len(els))
 	for i := range els {
@@ -1,14 +1,40 @@
 package service
 
 import (
-	"errors"
 	"time"
 
+	"github.com/pkg/errors"
+
 	"github.com/palestamp/barnacle/pkg/api"
 )
 
+var (
+	// ErrQueueReferenceInvalid - referenced queue can not be used by queue
+	ErrQueueReferenceInvalid = errors.New("referenced queue must share resource, backend and type with queue")
+	// ErrBackendMismatch - backend type differs from backend of resource
+	ErrBackendMismatch = errors.New("backend type does not match resource backend")
+)
+
+// servingStates are states in which queue serves message requests.
+var servingStates = []api.QueueState{
+	api.ActiveQueueState,
+	api.PausedQueueState,
+	api.DrainingQueueState,
+}
+
+func isServing(state api.QueueState) bool {
+	for _, st := range servingStates {
+		if st == state {
+			return true
+		}
+	}
+	return false
+}
+
 type ConnectorFactory interface {
 	Connector(api.BackendType) (api.Connector, error)
+	// InvalidateResource drops cached backends of resource.
+	InvalidateResource(api.ResourceID)
 }
 
 type Service struct {
@@ -25,23 +51,272 @@ func (s *Service) CreateQueue(qmi api.RegisterQueueRequest) error {
 		return err
 	}
 
+	rm, err := s.qms.GetResource(qmi.ResourceID)
+	if err != nil {
+		return err
+	}
+
+	if rm.BackendType != qmi.BackendType {
+		return ErrBackendMismatch
+	}
+
+	if _, err := s.resolveReferences(api.QueueMetadata{
+		QueueID:     qmi.QueueID,
+		ResourceID:  qmi.ResourceID,
+		BackendType: qmi.BackendType,
+		QueueType:   qmi.QueueType,
+		Options:     qmi.Options,
+	}); err != nil {
+		return err
+	}
+
 	if err := s.qms.RegisterQueueMetadata(qmi); err != nil {
 		return err
 	}
 
 	if err := s.createQueue(qmi); err != nil {
-		err1 := s.qms.DeleteQueueMetadata(qmi.QueueID)
-		if err1 != nil {
-			return errors.New("fatal: queue registration failed, stale artifacts")
+		// failed queue keeps the error for inspection until it is deleted
+		if err1 := s.qms.SetQueueState(qmi.QueueID, api.FailedQueueState, err.Error()); err1 != nil {
+			return errors.Wrapf(err1, "queue provisioning failed (%v), failure was not recorded", err)
+		}
+		return err
+	}
+
+	return s.qms.SetQueueState(qmi.QueueID, api.ActiveQueueState, "")
+}
+
+// DeleteQueue removes queue storage and metadata. Queue is switched into
+// deleting state first, so it stops serving requests and interrupted
+// deletion can be repeated. Queue with messages is deleted only with force,
+// queue referenced by other queues is never deleted. Failed queue has only
+// metadata, its storage may belong to another queue and is left intact.
+func (s *Service) DeleteQueue(dqr api.DeleteQueueRequest) error {
+	if err := dqr.Validate(); err != nil {
+		return err
+	}
+
+	referrers, err := s.referrers(dqr.QueueID)
+	if err != nil {
+		return err
+	}
+
+	if len(referrers) > 0 {
+		return errors.Wrapf(api.ErrQueueReferenced, "queue %s is used by %v", dqr.QueueID, referrers)
+	}
+
+	qm, err := s.qms.GetQueueMetadata(dqr.QueueID, api.QueueStates...)
+	if err != nil {
+		return err
+	}
+
+	// provisioning is atomic, failed one left nothing to drop
+	if qm.QueueState == api.FailedQueueState {
+		return s.qms.DeleteQueueMetadata(dqr.QueueID)
+	}
+
+	// emptiness is checked while queue is still in service, deleting
+	// state is never left, so refused deletion keeps queue state intact
+	if !dqr.Force && isServing(qm.QueueState) {
+		if err := s.checkQueueEmpty(qm); err != nil {
+			return err
 		}
+	}
+
+	manager, err := s.connectManagerByMetadata(qm)
+	if err != nil {
+		return err
+	}
+
+	if err := s.qms.SetQueueState(dqr.QueueID, api.DeletingQueueState, ""); err != nil {
+		return err
+	}
+
+	if err := manager.DeleteQueue(qm, dqr.Force); err != nil {
+		return err
+	}
+
+	return s.qms.DeleteQueueMetadata(dqr.QueueID)
+}
+
+// PurgeQueue removes messages matching request from queue
+// and returns number of removed messages.
+func (s *Service) PurgeQueue(pr api.PurgeRequest) (int64, error) {
+	if err := pr.Validate(); err != nil {
+		return 0, err
+	}
+
+	qm, err := s.qms.GetQueueMetadata(pr.QueueID, servingStates...)
+	if err != nil {
+		return 0, err
+	}
+
+	manager, err := s.connectManagerByMetadata(qm)
+	if err != nil {
+		return 0, err
+	}
+
+	return manager.PurgeQueue(qm, pr)
+}
+
+// PauseQueue stops delivery of queue messages to consumers, queue keeps
+// accepting new messages and acknowledgements of in flight ones.
+func (s *Service) PauseQueue(qid api.QueueID) error {
+	if _, err := s.qms.GetQueueMetadata(qid, servingStates...); err != nil {
 		return err
 	}
 
-	return s.qms.SetQueueState(qmi.QueueID, api.ActiveQueueState)
+	return s.qms.SetQueueState(qid, api.PausedQueueState, "")
+}
+
+// DrainQueue stops acceptance of new messages, messages which are already
+// in queue are delivered as usual, for example before queue deletion.
+func (s *Service) DrainQueue(qid api.QueueID) error {
+	if _, err := s.qms.GetQueueMetadata(qid, servingStates...); err != nil {
+		return err
+	}
+
+	return s.qms.SetQueueState(qid, api.DrainingQueueState, "")
+}
+
+// ResumeQueue returns paused or draining queue into service.
+func (s *Service) ResumeQueue(qid api.QueueID) error {
+	if _, err := s.qms.GetQueueMetadata(qid, servingStates...); err != nil {
+		return err
+	}
+
+	return s.qms.SetQueueState(qid, api.ActiveQueueState, "")
+}
+
+// QueueHistory returns state transitions of queue, the oldest first.
+func (s *Service) QueueHistory(qid api.QueueID) ([]api.QueueTransition, error) {
+	return s.qms.QueueHistory(qid)
+}
+
+// ListQueues returns a page of queues in all states.
+func (s *Service) ListQueues(lr api.ListQueuesRequest) (api.ListQueuesResponse, error) {
+	if err := lr.Validate(); err != nil {
+		return api.ListQueuesResponse{}, err
+	}
+
+	limit := lr.Limit
+	if limit == 0 {
+		limit = api.DefaultListLimit
+	}
+
+	// one extra queue tells whether there is the next page
+	qms, err := s.qms.ListQueues(lr.After, limit+1)
+	if err != nil {
+		return api.ListQueuesResponse{}, err
+	}
+
+	var next api.QueueID
+	if len(qms) > limit {
+		qms = qms[:limit]
+		next = qms[limit-1].QueueID
+	}
+	return api.ListQueuesResponse{Queues: qms, Next: next}, nil
+}
+
+// GetQueue returns metadata of queue in any state.
+func (s *Service) GetQueue(qid api.QueueID) (api.QueueMetadata, error) {
+	if err := qid.Validate(); err != nil {
+		return api.QueueMetadata{}, err
+	}
+
+	return s.qms.GetQueueMetadata(qid, api.QueueStates...)
+}
+
+func (s *Service) QueueStats(qid api.QueueID) (api.QueueStats, error) {
+	queue, err := s.connectQueueByID(qid)
+	if err != nil {
+		return api.QueueStats{}, err
+	}
+
+	return queue.Stats()
+}
+
+// PeekMessages returns a page of queue messages without leasing them.
+func (s *Service) PeekMessages(pr api.PeekRequest) (api.PeekResponse, error) {
+	if err := pr.Validate(); err != nil {
+		return api.PeekResponse{}, err
+	}
+
+	queue, err := s.connectQueueByID(pr.QueueID)
+	if err != nil {
+		return api.PeekResponse{}, err
+	}
+
+	limit := pr.Limit
+	if limit == 0 {
+		limit = api.DefaultListLimit
+	}
+
+	// one extra message tells whether there is the next page
+	msgs, err := queue.Peek(pr.Filter, pr.Cursor, limit+1)
+	if err != nil {
+		return api.PeekResponse{}, err
+	}
+
+	var next api.MessageID
+	if len(msgs) > limit {
+		msgs = msgs[:limit]
+		next = msgs[limit-1].ID
+	}
+	return api.PeekResponse{Messages: msgs, Next: next}, nil
+}
+
+// GetMessage returns single message without leasing it.
+func (s *Service) GetMessage(qid api.QueueID, mid api.MessageID) (api.PeekedMessage, error) {
+	if mid == "" {
+		return api.PeekedMessage{}, errors.New("message id can not be empty")
+	}
+
+	queue, err := s.connectQueueByID(qid)
+	if err != nil {
+		return api.PeekedMessage{}, err
+	}
+
+	return queue.Get(mid)
+}
+
+// CancelMessage removes message which is not in flight.
+func (s *Service) CancelMessage(qid api.QueueID, mid api.MessageID) error {
+	if mid == "" {
+		return errors.New("message id can not be empty")
+	}
+
+	queue, err := s.connectQueueByID(qid)
+	if err != nil {
+		return err
+	}
+
+	return queue.Cancel(mid)
+}
+
+// RescheduleMessage changes visibility time of message which is not in flight.
+func (s *Service) RescheduleMessage(rr api.RescheduleRequest) error {
+	if err := rr.Validate(); err != nil {
+		return err
+	}
+
+	queue, err := s.connectQueueByID(rr.QueueID)
+	if err != nil {
+		return err
+	}
+
+	return queue.Reschedule(rr)
 }
 
 func (s *Service) CreateMessage(emr api.EnqueueMessageRequest) (api.MessageID, error) {
-	queue, err := s.connectQueueByID(emr.QueueID)
+	if err := emr.Validate(); err != nil {
+		return "", err
+	}
+
+	if err := emr.DecodeData(); err != nil {
+		return "", err
+	}
+
+	queue, err := s.connectWritableQueue(emr.QueueID)
 	if err != nil {
 		return "", err
 	}
@@ -49,6 +324,66 @@ func (s *Service) CreateMessage(emr api.EnqueueMessageRequest) (api.MessageID, e
 	return queue.Add(emr)
 }
 
+// CreateMessages enqueues batch of messages. Invalid messages and messages
+// queue can not store are reported in results, the rest is enqueued with
+// a single call to queue.
+func (s *Service) CreateMessages(ebr api.EnqueueBatchRequest) ([]api.EnqueueResult, error) {
+	if err := ebr.Validate(); err != nil {
+		return nil, err
+	}
+
+	queue, err := s.connectWritableQueue(ebr.QueueID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]api.EnqueueResult, len(ebr.Messages))
+	valid := make([]api.EnqueueMessageRequest, 0, len(ebr.Messages))
+	positions := make([]int, 0, len(ebr.Messages))
+	for i, emr := range ebr.Messages {
+		if emr.QueueID == "" {
+			emr.QueueID = ebr.QueueID
+		}
+
+		if emr.QueueID != ebr.QueueID {
+			results[i].Error = "message queue differs from batch queue"
+			continue
+		}
+
+		if err := emr.Validate(); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		if err := emr.DecodeData(); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		if err := queue.CheckMessage(emr); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+
+		valid = append(valid, emr)
+		positions = append(positions, i)
+	}
+
+	if len(valid) == 0 {
+		return results, nil
+	}
+
+	ids, err := queue.AddBatch(valid)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, id := range ids {
+		results[positions[i]].ID = id
+	}
+	return results, nil
+}
+
 func (s *Service) AckMessage(qid api.QueueID, ackKey string) error {
 	queue, err := s.connectQueueByID(qid)
 	if err != nil {
@@ -58,8 +393,47 @@ func (s *Service) AckMessage(qid api.QueueID, ackKey string) error {
 	return queue.Ack(ackKey)
 }
 
-func (s *Service) PollQueue(qid api.QueueID, limit int, timeout, visibility time.Duration) ([]api.Message, error) {
+func (s *Service) AckMessages(abr api.AckBatchRequest) ([]string, error) {
+	if err := abr.Validate(); err != nil {
+		return nil, err
+	}
+
+	queue, err := s.connectQueueByID(abr.QueueID)
+	if err != nil {
+		return nil, err
+	}
+
+	return queue.AckBatch(abr.AckKeys)
+}
+
+func (s *Service) NackMessage(qid api.QueueID, ackKey string, delay time.Duration, reason string) error {
+	if delay < 0 {
+		return errors.New("delay can not be negative")
+	}
+
+	queue, err := s.connectQueueByID(qid)
+	if err != nil {
+		return err
+	}
+
+	return queue.Nack(ackKey, delay, reason)
+}
+
+func (s *Service) ExtendMessageVisibility(qid api.QueueID, ackKey string, visibility time.Duration) error {
+	if visibility <= 0 {
+		return errors.New("visibility must be positive")
+	}
+
 	queue, err := s.connectQueueByID(qid)
+	if err != nil {
+		return err
+	}
+
+	return queue.ExtendVisibility(ackKey, visibility)
+}
+
+func (s *Service) PollQueue(qid api.QueueID, limit int, timeout, visibility time.Duration) ([]api.Message, error) {
+	queue, err := s.connectPollableQueue(qid)
 	if err != nil {
 		return nil, err
 	}
@@ -67,12 +441,242 @@ func (s *Service) PollQueue(qid api.QueueID, limit int, timeout, visibility time
 	return api.Poll(queue, limit, timeout, visibility, &staticWaiter{})
 }
 
+const (
+	redriveBatchSize   = 100
+	redrivePollTimeout = 10 * time.Second
+	redriveVisibility  = time.Minute
+)
+
+// RedriveQueue moves messages from source queue into destination queue.
+// Messages are leased from source, enqueued into destination and only then
+// acknowledged, so a failed redrive may leave duplicates but never loses
+// messages. Progress is reported after every moved batch.
+func (s *Service) RedriveQueue(rr api.RedriveRequest, progress func(api.RedriveProgress)) (api.RedriveProgress, error) {
+	var p api.RedriveProgress
+	if err := rr.Validate(); err != nil {
+		return p, err
+	}
+
+	source, err := s.connectPollableQueue(rr.Source)
+	if err != nil {
+		return p, err
+	}
+
+	destination, err := s.connectWritableQueue(rr.Destination)
+	if err != nil {
+		return p, err
+	}
+
+	batchSize := rr.BatchSize
+	if batchSize == 0 {
+		batchSize = redriveBatchSize
+	}
+
+	for rr.Limit == 0 || p.Moved < rr.Limit {
+		limit := batchSize
+		if rr.Limit != 0 && rr.Limit-p.Moved < limit {
+			limit = rr.Limit - p.Moved
+		}
+
+		msgs, err := source.Poll(api.PollRequest{
+			Limit:      limit,
+			Deadline:   time.Now().Add(redrivePollTimeout),
+			Visibility: redriveVisibility,
+			Filter:     rr.Filter,
+		})
+		if err != nil {
+			return p, err
+		}
+
+		if len(msgs) == 0 {
+			break
+		}
+
+		for _, m := range msgs {
+			if _, err := destination.Add(api.EnqueueMessageRequest{
+				QueueID:    rr.Destination,
+				Data:       m.Data,
+				Attributes: m.Attributes,
+			}); err != nil {
+				return p, err
+			}
+
+			if err := source.Ack(m.AckKey); err != nil {
+				return p, err
+			}
+			p.Moved++
+		}
+
+		if progress != nil {
+			progress(p)
+		}
+	}
+
+	p.Done = true
+	return p, nil
+}
+
+// CreateResource verifies that resource storage is reachable through
+// connector of resource backend before persisting it.
 func (s *Service) CreateResource(rm api.ResourceMetadata) error {
+	if rm.BackendType == "" {
+		rm.BackendType = api.DefaultBackendType
+	}
+
+	if err := rm.Validate(); err != nil {
+		return err
+	}
+
+	if err := s.verifyResource(rm); err != nil {
+		return err
+	}
+
 	return s.qms.RegisterResource(rm)
 }
 
+func (s *Service) verifyResource(rm api.ResourceMetadata) error {
+	connector, err := s.connectorFactory.Connector(rm.BackendType)
+	if err != nil {
+		return err
+	}
+
+	return errors.Wrap(connector.Verify(rm.ConnOptions), "resource verification failed")
+}
+
+// GetResource returns resource with passwords in connection options redacted.
+func (s *Service) GetResource(rid api.ResourceID) (api.ResourceMetadata, error) {
+	if err := rid.Validate(); err != nil {
+		return api.ResourceMetadata{}, err
+	}
+
+	rm, err := s.qms.GetResource(rid)
+	if err != nil {
+		return api.ResourceMetadata{}, err
+	}
+
+	rm.ConnOptions = rm.ConnOptions.Redacted()
+	return rm, nil
+}
+
+// ListResources returns a page of resources with passwords in connection
+// options redacted.
+func (s *Service) ListResources(lr api.ListResourcesRequest) (api.ListResourcesResponse, error) {
+	if err := lr.Validate(); err != nil {
+		return api.ListResourcesResponse{}, err
+	}
+
+	limit := lr.Limit
+	if limit == 0 {
+		limit = api.DefaultListLimit
+	}
+
+	// one extra resource tells whether there is the next page
+	rms, err := s.qms.ListResources(lr.After, limit+1)
+	if err != nil {
+		return api.ListResourcesResponse{}, err
+	}
+
+	var next api.ResourceID
+	if len(rms) > limit {
+		rms = rms[:limit]
+		next = rms[limit-1].ResourceID
+	}
+
+	for i := range rms {
+		rms[i].ConnOptions = rms[i].ConnOptions.Redacted()
+	}
+	return api.ListResourcesResponse{Resources: rms, Next: next}, nil
+}
+
+// UpdateResource replaces resource connection options, backends connected
+// with previous options are dropped.
+func (s *Service) UpdateResource(rm api.ResourceMetadata) error {
+	if err := rm.ResourceID.Validate(); err != nil {
+		return err
+	}
+
+	current, err := s.qms.GetResource(rm.ResourceID)
+	if err != nil {
+		return err
+	}
+
+	// resources registered before backend was recorded are postgres ones
+	if current.BackendType == "" {
+		current.BackendType = api.DefaultBackendType
+	}
+
+	// backend type of resource can not be changed
+	if rm.BackendType != "" && rm.BackendType != current.BackendType {
+		return ErrBackendMismatch
+	}
+	rm.BackendType = current.BackendType
+
+	if err := s.verifyResource(rm); err != nil {
+		return err
+	}
+
+	if err := s.qms.UpdateResource(rm); err != nil {
+		return err
+	}
+
+	s.connectorFactory.InvalidateResource(rm.ResourceID)
+	return nil
+}
+
+// DeleteResource removes resource which does not host any queue.
+func (s *Service) DeleteResource(drr api.DeleteResourceRequest) error {
+	if err := drr.ResourceID.Validate(); err != nil {
+		return err
+	}
+
+	if err := s.qms.DeleteResource(drr.ResourceID); err != nil {
+		return err
+	}
+
+	s.connectorFactory.InvalidateResource(drr.ResourceID)
+	return nil
+}
+
 func (s *Service) connectQueueByID(id api.QueueID) (api.Queue, error) {
-	qm, err := s.qms.GetQueueMetadata(id, api.ActiveQueueState)
+	qm, err := s.qms.GetQueueMetadata(id, servingStates...)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.connectQueue(qm)
+}
+
+// connectPollableQueue connects queue which messages are going to be polled.
+func (s *Service) connectPollableQueue(id api.QueueID) (api.Queue, error) {
+	qm, err := s.qms.GetQueueMetadata(id, servingStates...)
+	if err != nil {
+		return nil, err
+	}
+
+	if qm.QueueState == api.PausedQueueState {
+		return nil, api.ErrQueuePaused
+	}
+
+	return s.connectQueue(qm)
+}
+
+// connectWritableQueue connects queue which is going to accept messages.
+func (s *Service) connectWritableQueue(id api.QueueID) (api.Queue, error) {
+	qm, err := s.qms.GetQueueMetadata(id, servingStates...)
+	if err != nil {
+		return nil, err
+	}
+
+	if qm.QueueState == api.DrainingQueueState {
+		return nil, api.ErrQueueDraining
+	}
+
+	return s.connectQueue(qm)
+}
+
+func (s *Service) connectQueue(qm api.QueueMetadata) (api.Queue, error) {
+	var err error
+	qm.References, err = s.resolveReferences(qm)
 	if err != nil {
 		return nil, err
 	}
@@ -85,6 +689,77 @@ func (s *Service) connectQueueByID(id api.QueueID) (api.Queue, error) {
 	return manager.ConnectToQueue(qm)
 }
 
+// checkQueueEmpty returns api.ErrQueueNotEmpty if queue holds any message,
+// expired ones included.
+func (s *Service) checkQueueEmpty(qm api.QueueMetadata) error {
+	queue, err := s.connectQueue(qm)
+	if err != nil {
+		return err
+	}
+
+	msgs, err := queue.Peek(api.PeekFilter{}, "", 1)
+	if err != nil {
+		return err
+	}
+
+	if len(msgs) > 0 {
+		return api.ErrQueueNotEmpty
+	}
+	return nil
+}
+
+// referrers returns queues which reference queue with given id in options.
+func (s *Service) referrers(qid api.QueueID) ([]api.QueueID, error) {
+	out := make([]api.QueueID, 0)
+	var after api.QueueID
+	for {
+		qms, err := s.qms.ListQueues(after, api.MaxListLimit)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, qm := range qms {
+			for _, ref := range qm.Options.QueueReferences() {
+				if ref == qid && qm.QueueID != qid {
+					out = append(out, qm.QueueID)
+					break
+				}
+			}
+		}
+
+		if len(qms) < api.MaxListLimit {
+			return out, nil
+		}
+		after = qms[len(qms)-1].QueueID
+	}
+}
+
+// resolveReferences loads metadata of queues referenced by queue options.
+// Messages are moved between queues by backend, so referenced queue
+// must be hosted on the same resource as referencing one.
+func (s *Service) resolveReferences(qm api.QueueMetadata) (map[api.QueueID]api.QueueMetadata, error) {
+	refs := make(map[api.QueueID]api.QueueMetadata)
+	for _, ref := range qm.Options.QueueReferences() {
+		if ref == qm.QueueID {
+			return nil, errors.Errorf("queue %s can not reference itself", ref)
+		}
+
+		rqm, err := s.qms.GetQueueMetadata(ref, servingStates...)
+		if err != nil {
+			return nil, errors.Wrapf(err, "referenced queue %s", ref)
+		}
+
+		if rqm.ResourceID != qm.ResourceID ||
+			rqm.BackendType != qm.BackendType ||
+			rqm.QueueType != qm.QueueType {
+			return nil, errors.Wrapf(ErrQueueReferenceInvalid, "referenced queue %s", ref)
+		}
+
+		refs[ref] = rqm
+	}
+	return refs, nil
+}
+
 func (s *Service) connectManagerByMetadata(qm api.QueueMetadata) (api.Manager, error) {
 	connector, err := s.connectorFactory.Connector(qm.BackendType)
 	if err != nil {
@@ -109,7 +784,7 @@ func (s *Service) connectManager(id api.QueueID, qss ...api.QueueState) (api.Man
 }
 
 func (s *Service) createQueue(qmi api.RegisterQueueRequest) error {
-	backend, err := s.connectManager(qmi.QueueID, api.ActiveQueueState, api.InactiveQueueState)
+	backend, err := s.connectManager(qmi.QueueID, api.ProvisioningQueueState)
 	if err != nil {
 		return err
 	}
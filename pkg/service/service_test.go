@@ -0,0 +1,649 @@
+package service_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/palestamp/barnacle/pkg/api"
+	"github.com/palestamp/barnacle/pkg/backends"
+	"github.com/palestamp/barnacle/pkg/backends/memory"
+	"github.com/palestamp/barnacle/pkg/backends/postgres"
+	"github.com/palestamp/barnacle/pkg/metadata"
+	"github.com/palestamp/barnacle/pkg/service"
+)
+
+func newService(t *testing.T) *service.Service {
+	registry := backends.NewRegistry()
+	registry.RegisterConnector(api.BackendType("memory"), memory.NewConnector())
+
+	svc := service.New(registry, metadata.NewMemoryStorage())
+	if err := svc.CreateResource(api.ResourceMetadata{ResourceID: "main", BackendType: "memory"}); err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func createQueue(t *testing.T, svc *service.Service, qid api.QueueID, ops api.QueueOptions) {
+	err := svc.CreateQueue(api.RegisterQueueRequest{
+		QueueID:     qid,
+		ResourceID:  "main",
+		BackendType: "memory",
+		QueueType:   api.SimpleDelayQueue,
+		Options:     ops,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServiceMessageFlow(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	id, err := svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	msgs, err := svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, id, msgs[0].ID)
+		assert.NoError(t, svc.AckMessage("erebor", msgs[0].AckKey))
+	}
+
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "moria", Data: "balrog"})
+	assert.Error(t, err)
+}
+
+func TestServiceCreateQueueFailure(t *testing.T) {
+	svc := newService(t)
+
+	err := svc.CreateQueue(api.RegisterQueueRequest{
+		QueueID:     "erebor",
+		ResourceID:  "main",
+		BackendType: "memory",
+		QueueType:   api.SimpleDelayQueue,
+		Options:     api.QueueOptions{"unknown": true},
+	})
+	assert.Error(t, err)
+
+	// failed creation is kept for inspection.
+	qm, err := svc.GetQueue("erebor")
+	assert.NoError(t, err)
+	assert.Equal(t, api.FailedQueueState, qm.QueueState)
+	assert.NotEmpty(t, qm.LastError)
+
+	history, err := svc.QueueHistory("erebor")
+	assert.NoError(t, err)
+	if assert.Len(t, history, 2) {
+		assert.Equal(t, api.ProvisioningQueueState, history[0].To)
+		assert.Equal(t, api.FailedQueueState, history[1].To)
+		assert.Equal(t, qm.LastError, history[1].Error)
+	}
+
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.Error(t, err)
+	assert.Error(t, svc.ResumeQueue("erebor"))
+
+	// failed queue id can be reused after deletion.
+	assert.NoError(t, svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor"}))
+	createQueue(t, svc, "erebor", nil)
+}
+
+func TestServiceDeadLetterQueue(t *testing.T) {
+	svc := newService(t)
+
+	// dead letter queue must exist before queue creation.
+	err := svc.CreateQueue(api.RegisterQueueRequest{
+		QueueID:     "erebor",
+		ResourceID:  "main",
+		BackendType: "memory",
+		QueueType:   api.SimpleDelayQueue,
+		Options:     api.QueueOptions{"max_attempts": 1, "dead_letter_queue": "erebor_dead"},
+	})
+	assert.Error(t, err)
+
+	createQueue(t, svc, "erebor_dead", nil)
+	createQueue(t, svc, "erebor", api.QueueOptions{"max_attempts": 1, "dead_letter_queue": "erebor_dead"})
+
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	msgs, err := svc.PollQueue("erebor", 1, 10*time.Millisecond, 10*time.Millisecond)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	time.Sleep(20 * time.Millisecond)
+
+	msgs, err = svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	msgs, err = svc.PollQueue("erebor_dead", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "smaug", msgs[0].Data)
+	}
+}
+
+func TestServiceExpiryQueue(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor_expired", nil)
+	createQueue(t, svc, "erebor", api.QueueOptions{"default_ttl": "10ms", "expiry_queue": "erebor_expired"})
+
+	_, err := svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	// explicit ttl overrides queue default.
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{
+		QueueID: "erebor",
+		Data:    "balrog",
+		TTL:     api.Delay{Duration: time.Minute},
+	})
+	assert.NoError(t, err)
+
+	time.Sleep(20 * time.Millisecond)
+
+	msgs, err := svc.PollQueue("erebor", 10, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "balrog", msgs[0].Data)
+		assert.NotNil(t, msgs[0].ExpiresAt)
+	}
+
+	msgs, err = svc.PollQueue("erebor_expired", 10, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "smaug", msgs[0].Data)
+		assert.Nil(t, msgs[0].ExpiresAt)
+	}
+}
+
+func TestServiceRedriveQueue(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+	createQueue(t, svc, "erebor_dead", nil)
+
+	for i := 0; i < 3; i++ {
+		_, err := svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor_dead", Data: "smaug"})
+		assert.NoError(t, err)
+	}
+
+	var reports []api.RedriveProgress
+	p, err := svc.RedriveQueue(api.RedriveRequest{
+		Source:      "erebor_dead",
+		Destination: "erebor",
+		Limit:       2,
+		BatchSize:   1,
+	}, func(p api.RedriveProgress) {
+		reports = append(reports, p)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, api.RedriveProgress{Moved: 2, Done: true}, p)
+	assert.Equal(t, []api.RedriveProgress{{Moved: 1}, {Moved: 2}}, reports)
+
+	p, err = svc.RedriveQueue(api.RedriveRequest{Source: "erebor_dead", Destination: "erebor"}, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, p.Moved)
+
+	msgs, err := svc.PollQueue("erebor", 10, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 3)
+
+	_, err = svc.RedriveQueue(api.RedriveRequest{Source: "erebor", Destination: "erebor"}, nil)
+	assert.Error(t, err)
+}
+
+func TestServiceRetryPolicy(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", api.QueueOptions{
+		"retry_policy": "fixed",
+		"retry_delay":  "30ms",
+	})
+
+	_, err := svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	msgs, err := svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	if !assert.Len(t, msgs, 1) {
+		return
+	}
+
+	// nack without delay falls back to retry policy.
+	assert.NoError(t, svc.NackMessage("erebor", msgs[0].AckKey, 0, ""))
+
+	msgs, err = svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Empty(t, msgs)
+
+	msgs, err = svc.PollQueue("erebor", 1, 100*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+}
+
+func TestServiceCreateMessages(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	results, err := svc.CreateMessages(api.EnqueueBatchRequest{
+		QueueID: "erebor",
+		Messages: []api.EnqueueMessageRequest{
+			{Data: "smaug"},
+			{Data: "balrog", Delay: api.Delay{Duration: -time.Second}},
+			{QueueID: "moria", Data: "durin"},
+			{QueueID: "erebor", Data: "thorin"},
+			{Data: "gollum", Attributes: api.MessageAttributes{"ring": []string{"one"}}},
+		},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, results, 5) {
+		assert.NotEmpty(t, results[0].ID)
+		assert.NotEmpty(t, results[1].Error)
+		assert.NotEmpty(t, results[2].Error)
+		assert.NotEmpty(t, results[3].ID)
+		assert.NotEmpty(t, results[4].Error)
+	}
+
+	msgs, err := svc.PollQueue("erebor", 10, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 2) {
+		assert.Equal(t, results[0].ID, msgs[0].ID)
+		assert.Equal(t, results[3].ID, msgs[1].ID)
+	}
+}
+
+func TestServiceDeleteQueue(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	_, err := svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	err = svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor"})
+	assert.Equal(t, api.ErrQueueNotEmpty, err)
+
+	// refused deletion leaves queue in service.
+	msgs, err := svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	history, err := svc.QueueHistory("erebor")
+	assert.NoError(t, err)
+	for _, tr := range history {
+		assert.NotEqual(t, api.DeletingQueueState, tr.To)
+	}
+
+	assert.NoError(t, svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor", Force: true}))
+	assert.Error(t, svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor"}))
+
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.Error(t, err)
+
+	// queue id can be reused after deletion.
+	createQueue(t, svc, "erebor", nil)
+	assert.NoError(t, svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor"}))
+}
+
+func TestServicePurgeQueue(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	_, err := svc.CreateMessages(api.EnqueueBatchRequest{
+		QueueID: "erebor",
+		Messages: []api.EnqueueMessageRequest{
+			{Data: "smaug"},
+			{Data: "balrog"},
+			{Data: "witch-king", Delay: api.Delay{Duration: time.Minute}},
+			{Data: "saruman", Delay: api.Delay{Duration: time.Hour}},
+		},
+	})
+	assert.NoError(t, err)
+
+	msgs, err := svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	_, err = svc.PurgeQueue(api.PurgeRequest{QueueID: "erebor", Only: "stale"})
+	assert.Error(t, err)
+
+	// in flight message is neither visible nor delayed.
+	purged, err := svc.PurgeQueue(api.PurgeRequest{QueueID: "erebor", Only: api.PurgeVisible})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	purged, err = svc.PurgeQueue(api.PurgeRequest{
+		QueueID:         "erebor",
+		Only:            api.PurgeDelayed,
+		ScheduledBefore: time.Now().Add(10 * time.Minute),
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	purged, err = svc.PurgeQueue(api.PurgeRequest{QueueID: "erebor"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+}
+
+func TestServiceListQueues(t *testing.T) {
+	svc := newService(t)
+	for _, qid := range []api.QueueID{"moria", "erebor", "isengard"} {
+		createQueue(t, svc, qid, api.QueueOptions{"max_attempts": 0})
+	}
+
+	resp, err := svc.ListQueues(api.ListQueuesRequest{Limit: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Queues, 2) {
+		assert.Equal(t, api.QueueID("erebor"), resp.Queues[0].QueueID)
+		assert.Equal(t, api.QueueID("isengard"), resp.Queues[1].QueueID)
+		assert.Equal(t, api.ActiveQueueState, resp.Queues[0].QueueState)
+	}
+	assert.Equal(t, api.QueueID("isengard"), resp.Next)
+
+	resp, err = svc.ListQueues(api.ListQueuesRequest{After: resp.Next, Limit: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Queues, 1) {
+		assert.Equal(t, api.QueueID("moria"), resp.Queues[0].QueueID)
+	}
+	assert.Equal(t, api.QueueID(""), resp.Next)
+
+	qm, err := svc.GetQueue("moria")
+	assert.NoError(t, err)
+	assert.Equal(t, api.ResourceID("main"), qm.ResourceID)
+	assert.Equal(t, api.QueueOptions{"max_attempts": float64(0)}, qm.Options)
+
+	_, err = svc.GetQueue("mordor")
+	assert.Error(t, err)
+}
+
+func TestServiceResources(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	assert.NoError(t, svc.CreateResource(api.ResourceMetadata{ResourceID: "spare", BackendType: "memory"}))
+
+	resp, err := svc.ListResources(api.ListResourcesRequest{Limit: 1})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Resources, 1) {
+		assert.Equal(t, api.ResourceID("main"), resp.Resources[0].ResourceID)
+	}
+	assert.Equal(t, api.ResourceID("main"), resp.Next)
+
+	assert.Error(t, svc.UpdateResource(api.ResourceMetadata{ResourceID: "mordor"}))
+	assert.NoError(t, svc.UpdateResource(api.ResourceMetadata{ResourceID: "main"}))
+
+	// update keeps hosted queues working.
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	assert.Error(t, svc.DeleteResource(api.DeleteResourceRequest{ResourceID: "main"}))
+	assert.NoError(t, svc.DeleteResource(api.DeleteResourceRequest{ResourceID: "spare"}))
+
+	_, err = svc.GetResource("spare")
+	assert.Error(t, err)
+}
+
+func TestServiceResourceBackend(t *testing.T) {
+	svc := newService(t)
+
+	// resource options are verified by connector of resource backend,
+	// empty backend defaults to postgres which is not registered here.
+	err := svc.CreateResource(api.ResourceMetadata{ResourceID: "spare"})
+	assert.Equal(t, backends.ErrConnectorNotFound, errors.Cause(err))
+	assert.Error(t, svc.CreateResource(api.ResourceMetadata{ResourceID: "spare", BackendType: "redis"}))
+	assert.Error(t, svc.CreateResource(api.ResourceMetadata{
+		ResourceID:  "spare",
+		BackendType: "memory",
+		ConnOptions: api.ResourceConnOptions{"uri": "memory://"},
+	}))
+
+	assert.Error(t, svc.UpdateResource(api.ResourceMetadata{
+		ResourceID:  "main",
+		ConnOptions: api.ResourceConnOptions{"uri": "memory://"},
+	}))
+	assert.Error(t, svc.UpdateResource(api.ResourceMetadata{ResourceID: "main", BackendType: "postgres"}))
+
+	err = svc.CreateQueue(api.RegisterQueueRequest{
+		QueueID:     "erebor",
+		ResourceID:  "main",
+		BackendType: "postgres",
+		QueueType:   api.SimpleDelayQueue,
+	})
+	assert.Equal(t, service.ErrBackendMismatch, err)
+
+	rm, err := svc.GetResource("main")
+	assert.NoError(t, err)
+	assert.Equal(t, api.BackendType("memory"), rm.BackendType)
+}
+
+func TestServicePeekMessages(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	_, err := svc.CreateMessages(api.EnqueueBatchRequest{
+		QueueID: "erebor",
+		Messages: []api.EnqueueMessageRequest{
+			{Data: "smaug"},
+			{Data: "balrog"},
+			{Data: "witch-king", Delay: api.Delay{Duration: time.Minute}},
+		},
+	})
+	assert.NoError(t, err)
+
+	polled, err := svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, polled, 1)
+
+	resp, err := svc.PeekMessages(api.PeekRequest{QueueID: "erebor", Limit: 2})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Messages, 2) {
+		assert.Equal(t, api.InFlightMessageState, resp.Messages[0].State)
+		assert.Equal(t, 1, resp.Messages[0].Attempts)
+		assert.Empty(t, resp.Messages[0].AckKey)
+		assert.Equal(t, api.VisibleMessageState, resp.Messages[1].State)
+	}
+	assert.Equal(t, resp.Messages[1].ID, resp.Next)
+
+	resp, err = svc.PeekMessages(api.PeekRequest{QueueID: "erebor", Cursor: resp.Next})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Messages, 1) {
+		assert.Equal(t, api.DelayedMessageState, resp.Messages[0].State)
+	}
+	assert.Empty(t, resp.Next)
+
+	resp, err = svc.PeekMessages(api.PeekRequest{
+		QueueID: "erebor",
+		Filter:  api.PeekFilter{State: api.VisibleMessageState},
+	})
+	assert.NoError(t, err)
+	if assert.Len(t, resp.Messages, 1) {
+		assert.Equal(t, "balrog", resp.Messages[0].Data)
+	}
+
+	// peek does not lease messages.
+	msgs, err := svc.PollQueue("erebor", 10, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	_, err = svc.PeekMessages(api.PeekRequest{QueueID: "erebor", Filter: api.PeekFilter{State: "lost"}})
+	assert.Error(t, err)
+}
+
+func TestServiceMessageByID(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	smaug, err := svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+	balrog, err := svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "balrog"})
+	assert.NoError(t, err)
+
+	msg, err := svc.GetMessage("erebor", balrog)
+	assert.NoError(t, err)
+	assert.Equal(t, "balrog", msg.Data)
+	assert.Equal(t, api.VisibleMessageState, msg.State)
+
+	err = svc.RescheduleMessage(api.RescheduleRequest{
+		QueueID: "erebor",
+		ID:      balrog,
+		Delay:   api.Delay{Duration: time.Minute},
+	})
+	assert.NoError(t, err)
+
+	msg, err = svc.GetMessage("erebor", balrog)
+	assert.NoError(t, err)
+	assert.Equal(t, api.DelayedMessageState, msg.State)
+
+	polled, err := svc.PollQueue("erebor", 10, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, polled, 1) {
+		assert.Equal(t, smaug, polled[0].ID)
+	}
+
+	// in flight message belongs to consumer.
+	assert.Equal(t, api.ErrMessageInFlight, svc.CancelMessage("erebor", smaug))
+	err = svc.RescheduleMessage(api.RescheduleRequest{QueueID: "erebor", ID: smaug})
+	assert.Equal(t, api.ErrMessageInFlight, err)
+
+	assert.NoError(t, svc.CancelMessage("erebor", balrog))
+	_, err = svc.GetMessage("erebor", balrog)
+	assert.Equal(t, api.ErrMessageNotFound, err)
+	assert.Equal(t, api.ErrMessageNotFound, svc.CancelMessage("erebor", balrog))
+	assert.Equal(t, api.ErrMessageNotFound, svc.CancelMessage("erebor", "smaug"))
+}
+
+func TestServicePauseQueue(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	assert.NoError(t, svc.PauseQueue("erebor"))
+
+	qm, err := svc.GetQueue("erebor")
+	assert.NoError(t, err)
+	assert.Equal(t, api.PausedQueueState, qm.QueueState)
+
+	// paused queue accepts messages but does not deliver them.
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	_, err = svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.Equal(t, api.ErrQueuePaused, err)
+
+	stats, err := svc.QueueStats("erebor")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), stats.Visible)
+
+	assert.NoError(t, svc.ResumeQueue("erebor"))
+
+	msgs, err := svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	assert.Error(t, svc.PauseQueue("moria"))
+}
+
+func TestServiceDrainQueue(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor", nil)
+
+	_, err := svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, svc.DrainQueue("erebor"))
+
+	// draining queue delivers messages but does not accept new ones.
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "balrog"})
+	assert.Equal(t, api.ErrQueueDraining, err)
+
+	msgs, err := svc.PollQueue("erebor", 10, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	assert.Len(t, msgs, 1)
+
+	assert.NoError(t, svc.PauseQueue("erebor"))
+	assert.NoError(t, svc.ResumeQueue("erebor"))
+
+	history, err := svc.QueueHistory("erebor")
+	assert.NoError(t, err)
+	states := make([]api.QueueState, 0, len(history))
+	for _, qt := range history {
+		states = append(states, qt.To)
+	}
+	assert.Equal(t, []api.QueueState{
+		api.ProvisioningQueueState,
+		api.ActiveQueueState,
+		api.DrainingQueueState,
+		api.PausedQueueState,
+		api.ActiveQueueState,
+	}, states)
+}
+
+func TestServiceDeleteReferencedQueue(t *testing.T) {
+	svc := newService(t)
+	createQueue(t, svc, "erebor_dead", nil)
+	createQueue(t, svc, "erebor", api.QueueOptions{"max_attempts": 1, "dead_letter_queue": "erebor_dead"})
+
+	err := svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor_dead", Force: true})
+	assert.Equal(t, api.ErrQueueReferenced, errors.Cause(err))
+
+	// refused deletion leaves both queues in service.
+	qm, err := svc.GetQueue("erebor_dead")
+	assert.NoError(t, err)
+	assert.Equal(t, api.ActiveQueueState, qm.QueueState)
+
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	assert.NoError(t, svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor", Force: true}))
+	assert.NoError(t, svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor_dead"}))
+}
+
+const testDbURI = "postgresql://postgres@localhost:5434/barnacle"
+
+func TestServiceDeleteFailedQueueKeepsStorage(t *testing.T) {
+	registry := backends.NewRegistry()
+	registry.RegisterConnector(api.BackendType("postgres"), postgres.NewConnector())
+	svc := service.New(registry, metadata.NewMemoryStorage())
+
+	err := svc.CreateResource(api.ResourceMetadata{
+		ResourceID:  "main",
+		BackendType: "postgres",
+		ConnOptions: api.ResourceConnOptions{"uri": testDbURI},
+	})
+	if err != nil {
+		t.Skipf("test database is not available: %v", err)
+	}
+
+	create := func(qid api.QueueID) error {
+		return svc.CreateQueue(api.RegisterQueueRequest{
+			QueueID:     qid,
+			ResourceID:  "main",
+			BackendType: "postgres",
+			QueueType:   api.SimpleDelayQueue,
+			Options:     api.QueueOptions{"table": "ut_collision"},
+		})
+	}
+
+	if err := create("erebor"); err != nil {
+		t.Fatal(err)
+	}
+	defer svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "erebor", Force: true})
+
+	_, err = svc.CreateMessage(api.EnqueueMessageRequest{QueueID: "erebor", Data: "smaug"})
+	assert.NoError(t, err)
+
+	// table of erebor can not be created again.
+	assert.Error(t, create("moria"))
+	qm, err := svc.GetQueue("moria")
+	assert.NoError(t, err)
+	assert.Equal(t, api.FailedQueueState, qm.QueueState)
+
+	assert.NoError(t, svc.DeleteQueue(api.DeleteQueueRequest{QueueID: "moria", Force: true}))
+
+	msgs, err := svc.PollQueue("erebor", 1, 10*time.Millisecond, time.Minute)
+	assert.NoError(t, err)
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "smaug", msgs[0].Data)
+	}
+}
@@ -0,0 +1 @@
+ALTER TABLE barnacle.resource_configs DROP COLUMN backend_type;
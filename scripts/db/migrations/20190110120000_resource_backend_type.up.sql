@@ -0,0 +1,6 @@
+-- Resources created before backend type was introduced were postgres ones.
+ALTER TABLE barnacle.resource_configs
+    ADD COLUMN backend_type varchar(64) NOT NULL DEFAULT 'postgres';
+
+ALTER TABLE barnacle.resource_configs
+    ALTER COLUMN backend_type DROP DEFAULT;
@@ -0,0 +1,10 @@
+DROP TABLE barnacle.queue_state_history;
+
+ALTER TABLE barnacle.queue_configs
+    DROP COLUMN created_at,
+    DROP COLUMN state_changed_at,
+    DROP COLUMN last_error;
+
+-- States unknown before lifecycle are mapped onto the closest previous ones.
+UPDATE barnacle.queue_configs SET queue_state = 'inactive' WHERE queue_state IN ('provisioning', 'failed');
+UPDATE barnacle.queue_configs SET queue_state = 'active' WHERE queue_state IN ('paused', 'draining');
@@ -0,0 +1,19 @@
+UPDATE barnacle.queue_configs SET queue_state = 'provisioning' WHERE queue_state = 'inactive';
+
+-- Timestamps of existing queues are unknown, migration time is used instead.
+ALTER TABLE barnacle.queue_configs
+    ADD COLUMN created_at timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN state_changed_at timestamptz NOT NULL DEFAULT now(),
+    ADD COLUMN last_error text;
+
+
+CREATE TABLE barnacle.queue_state_history (
+    id bigserial PRIMARY KEY,
+    queue_id varchar(63) REFERENCES barnacle.queue_configs(queue_id) ON DELETE CASCADE NOT NULL,
+    from_state varchar(32),
+    to_state varchar(32) NOT NULL,
+    error text,
+    changed_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX queue_state_history_queue_id_idx ON barnacle.queue_state_history (queue_id, id);